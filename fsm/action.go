@@ -0,0 +1,108 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTransitionRejected 守卫函数拒绝了状态切换，实际返回的错误类型为 *GuardError
+var ErrTransitionRejected = errors.New("transition rejected")
+
+// GuardError 守卫函数拒绝状态切换时返回的错误，Err 为守卫函数返回的原始错误
+type GuardError struct {
+	// Transition 被拒绝的转换名称，没有对应的转换时为空
+	Transition string
+	From       State
+	To         State
+	Err        error
+}
+
+func (t *GuardError) Error() string {
+	return fmt.Sprintf("%s: transition=%s fromState=%s toState=%s: %s", ErrTransitionRejected, t.Transition, t.From, t.To, t.Err)
+}
+
+func (t *GuardError) Is(target error) bool {
+	return target == ErrTransitionRejected
+}
+
+func (t *GuardError) Unwrap() error {
+	return t.Err
+}
+
+// TransitionContext 一次状态切换的信息，传递给守卫函数和动作。
+// 守卫函数和动作在状态机加锁的情况下执行，不能再调用同一个状态机中会加锁的方法，需要读取参数时请使用 Parameters
+type TransitionContext struct {
+	Machine *StateMachine
+	// Transition 触发切换的转换，手动切换并且没有对应的转换时为空
+	Transition *Transition
+	From       State
+	To         State
+	// Parameter 触发切换的参数，由 SetParameterValue 触发时才有值
+	Parameter *Parameter
+	// Parameters 状态机的所有参数，只读
+	Parameters map[string]*Parameter
+	// Event 触发切换的事件，由 Fire 触发时才有值
+	Event string
+	// Payload 事件携带的数据
+	Payload any
+}
+
+// Action 进入状态、离开状态或者执行转换时的动作
+type Action func(ctx *TransitionContext)
+
+// Guard 守卫函数，返回错误时拒绝本次状态切换
+type Guard func(ctx *TransitionContext) error
+
+// OnEnter 添加进入 state 时执行的动作，按照添加顺序执行
+func (t *StateMachine) OnEnter(state State, action Action) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.enterActions == nil {
+		t.enterActions = make(map[State][]Action)
+	}
+	t.enterActions[state] = append(t.enterActions[state], action)
+}
+
+// OnExit 添加离开 state 时执行的动作，按照添加顺序执行
+func (t *StateMachine) OnExit(state State, action Action) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.exitActions == nil {
+		t.exitActions = make(map[State][]Action)
+	}
+	t.exitActions[state] = append(t.exitActions[state], action)
+}
+
+// AddGuard 添加对所有状态切换生效的守卫函数，在 Transition.Guards 之前执行
+func (t *StateMachine) AddGuard(guard Guard) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.guards = append(t.guards, guard)
+}
+
+// transit 执行一次状态切换，顺序为：状态机的守卫函数、转换的守卫函数、停止绑定到 From 的子状态机、离开 From 的动作、转换的动作、
+// 切换状态、进入 To 的动作、启动绑定到 To 的子状态机、状态切换回调。任意一个守卫函数返回错误时不会执行任何动作，状态保持不变
+func (t *StateMachine) transit(ctx *TransitionContext) (err error) {
+	guards := t.guards
+	if ctx.Transition != nil {
+		guards = append(guards[:len(guards):len(guards)], ctx.Transition.Guards...)
+	}
+	for _, guard := range guards {
+		if err = guard(ctx); err != nil {
+			guardErr := &GuardError{From: ctx.From, To: ctx.To, Err: err}
+			if ctx.Transition != nil {
+				guardErr.Transition = ctx.Transition.Name
+			}
+			return guardErr
+		}
+	}
+
+	t.leave(ctx)
+	if ctx.Transition != nil {
+		for _, action := range ctx.Transition.Actions {
+			action(ctx)
+		}
+	}
+	t.enter(ctx, false)
+	return nil
+}
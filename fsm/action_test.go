@@ -0,0 +1,108 @@
+package fsm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateMachineActions(t *testing.T) {
+	sm := NewStateMachine("Door")
+	sm.AddValidTransition("closed", []State{"open", "locked"})
+	sm.AddValidTransition("open", []State{"closed"})
+	sm.AddValidTransition("locked", []State{"closed"})
+	push := &Parameter{Name: "push", Value: "false", Type: ParameterTypeBool}
+	sm.AddParameter(push)
+
+	var calls []string
+	record := func(name string) Action {
+		return func(ctx *TransitionContext) {
+			calls = append(calls, name+":"+ctx.From+"->"+ctx.To)
+		}
+	}
+	sm.OnExit("closed", record("exit closed"))
+	sm.OnEnter("open", record("enter open"))
+	sm.OnEnter("open", record("enter open 2"))
+	sm.SetStateUpdatedCallback(func(from, to State) {
+		calls = append(calls, "callback:"+from+"->"+to)
+	})
+
+	var triggeredBy string
+	locked := errors.New("door is locked")
+	sm.AddAutoTransition(&Transition{
+		Name: "closed_open",
+		From: "closed",
+		To:   "open",
+		Conditions: map[string]ICondition{
+			"pushed": &Condition{CompareType: CompareTypeEqual, ParameterName: "push", Value: "true"},
+		},
+		Guards: []Guard{func(ctx *TransitionContext) error {
+			triggeredBy = ctx.Parameter.Name
+			if ctx.Parameters["push"].Value == "true" && ctx.Machine.CurrentState == "locked" {
+				return locked
+			}
+			return nil
+		}},
+		Actions: []Action{record("closed_open")},
+	}, push)
+
+	assert.Equal(t, nil, sm.AutoTransit())
+	assert.Equal(t, "closed", sm.GetCurrentState())
+	assert.Equal(t, []string{"callback:Entry->closed"}, calls)
+
+	calls = nil
+	assert.Equal(t, nil, sm.SetParameterValue("push", "true"))
+	assert.Equal(t, "open", sm.GetCurrentState())
+	assert.Equal(t, "push", triggeredBy)
+	assert.Equal(t, []string{
+		"exit closed:closed->open",
+		"closed_open:closed->open",
+		"enter open:closed->open",
+		"enter open 2:closed->open",
+		"callback:closed->open",
+	}, calls)
+
+	// 状态机的守卫函数对手动切换同样生效
+	sm.AddGuard(func(ctx *TransitionContext) error {
+		if ctx.To == "locked" {
+			return locked
+		}
+		return nil
+	})
+	assert.Equal(t, nil, sm.SetState("closed"))
+	err := sm.SetState("locked")
+	assert.Equal(t, true, errors.Is(err, ErrTransitionRejected))
+	assert.Equal(t, true, errors.Is(err, locked))
+	var guardErr *GuardError
+	assert.Equal(t, true, errors.As(err, &guardErr))
+	assert.Equal(t, "closed", guardErr.From)
+	assert.Equal(t, "closed", sm.GetCurrentState())
+
+	// 没有登记的切换
+	assert.NotEqual(t, nil, sm.SetState("nowhere"))
+}
+
+func TestAutoTransitGuardError(t *testing.T) {
+	sm := NewStateMachine("Door")
+	sm.AddValidTransition("closed", []State{"open"})
+	parameter := &Parameter{Name: "push", Value: "true", Type: ParameterTypeBool}
+	sm.AddParameter(parameter)
+	sm.AddAutoTransition(&Transition{Name: "closed_open", From: "closed", To: "open", Conditions: map[string]ICondition{
+		"push": &Condition{CompareType: CompareTypeEqual, ParameterName: "push", Value: "true"},
+	}}, parameter)
+	locked := errors.New("locked")
+	sm.AddGuard(func(ctx *TransitionContext) error {
+		if ctx.To == "open" {
+			return locked
+		}
+		return nil
+	})
+
+	// 守卫函数拒绝时 AutoTransit 返回错误
+	assert.Equal(t, nil, sm.AutoTransit())
+	err := sm.AutoTransit()
+	assert.Equal(t, true, errors.Is(err, ErrTransitionRejected))
+	assert.Equal(t, true, errors.Is(err, locked))
+	assert.Equal(t, "closed", sm.GetCurrentState())
+}
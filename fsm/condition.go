@@ -133,3 +133,8 @@ func (t *Condition) CompareString(value string) bool {
 
 	return false
 }
+
+// String 返回形如 speed > 5 的条件描述
+func (t *Condition) String() string {
+	return t.ParameterName + " " + t.CompareType + " " + t.Value
+}
@@ -28,3 +28,12 @@ func (t *ConditionGroup) Compare(parameters map[string]*Parameter) bool {
 
 	return count == len(t.Conditions)
 }
+
+// String 返回形如 (speed > 0 and speed < 5) 的条件组描述，组内条件按照名称排序
+func (t *ConditionGroup) String() string {
+	compareType := t.CompareType
+	if compareType == "" {
+		compareType = ConditionGroupCompareTypeAnd
+	}
+	return "(" + describeConditions(t.Conditions, " "+compareType+" ") + ")"
+}
@@ -0,0 +1,479 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidDefinition 状态机定义没有通过校验，Validate 返回的每个错误都会包装它
+var ErrInvalidDefinition = errors.New("invalid state machine definition")
+
+// MachineDefinition 状态机的声明式定义，可以用 YAML 或 JSON 描述，也可以由已有的状态机导出
+type MachineDefinition struct {
+	// Name 状态机的名称
+	Name string `json:"name" yaml:"name"`
+	// States 所有状态列表，第一个状态是 AutoTransit 从 Entry 切换到的状态
+	States []State `json:"states,omitempty" yaml:"states,omitempty"`
+	// CurrentState 当前状态，为空时为 Entry
+	CurrentState State `json:"currentState,omitempty" yaml:"currentState,omitempty"`
+	// Parameters 参数列表
+	Parameters []*ParameterDefinition `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	// ValidTransitions 一个状态可以切换为哪些状态
+	ValidTransitions map[State][]State `json:"validTransitions,omitempty" yaml:"validTransitions,omitempty"`
+	// Transitions 自动状态转换列表
+	Transitions []*TransitionDefinition `json:"transitions,omitempty" yaml:"transitions,omitempty"`
+	// SubMachines 内部子状态机
+	SubMachines []*MachineDefinition `json:"subMachines,omitempty" yaml:"subMachines,omitempty"`
+	// ParentState 子状态机绑定的父状态机状态
+	ParentState State `json:"parentState,omitempty" yaml:"parentState,omitempty"`
+	// History 绑定的子状态机重新启动时如何恢复状态
+	History HistoryType `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// ParameterDefinition 状态切换参数的定义
+type ParameterDefinition struct {
+	Name  string        `json:"name" yaml:"name"`
+	Type  ParameterType `json:"type" yaml:"type"`
+	Value string        `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// TransitionDefinition 自动状态转换的定义
+type TransitionDefinition struct {
+	Name string `json:"name" yaml:"name"`
+	From State  `json:"from" yaml:"from"`
+	To   State  `json:"to" yaml:"to"`
+	// Event 处理的事件名称，设置后只能通过 Fire 触发，不能再设置 Parameters
+	Event string `json:"event,omitempty" yaml:"event,omitempty"`
+	// Parameters 这些参数的值变化时检查这个转换，为空时使用条件中引用的所有参数
+	Parameters []string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	// Conditions 满足其中一个条件即可转换，没有条件时总是可以转换
+	Conditions map[string]*ConditionDefinition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+}
+
+// ConditionDefinition 条件的定义，设置了 Group 或者 Conditions 时为条件组，否则为比较参数的单个条件
+type ConditionDefinition struct {
+	// Parameter 单个条件比较的参数名称
+	Parameter string `json:"parameter,omitempty" yaml:"parameter,omitempty"`
+	// Compare 单个条件的比较方式，比如 == 或 >=
+	Compare CompareType `json:"compare,omitempty" yaml:"compare,omitempty"`
+	// Value 单个条件比较的值
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// Group 条件组的组合方式 and 或 or，默认 and
+	Group ConditionGroupCompareType `json:"group,omitempty" yaml:"group,omitempty"`
+	// Conditions 条件组内的条件
+	Conditions map[string]*ConditionDefinition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+}
+
+func (t *ConditionDefinition) isGroup() bool {
+	return t.Group != "" || t.Conditions != nil
+}
+
+// ParseDefinition 解析 YAML 或 JSON 格式的状态机定义，以 { 开头的内容按照 JSON 解析，不认识的字段会返回错误
+func ParseDefinition(data []byte) (def *MachineDefinition, err error) {
+	def = new(MachineDefinition)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(def)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(def)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// LoadStateMachine 解析 YAML 或 JSON 格式的状态机定义，校验通过后生成状态机
+func LoadStateMachine(data []byte) (sm *StateMachine, err error) {
+	def, err := ParseDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewStateMachineFromDefinition(def)
+}
+
+// NewStateMachineFromDefinition 校验定义并生成状态机，包括所有子状态机
+func NewStateMachineFromDefinition(def *MachineDefinition) (sm *StateMachine, err error) {
+	if err = def.Validate(); err != nil {
+		return nil, err
+	}
+	return def.build(), nil
+}
+
+// Validate 校验定义中的状态、参数、转换和条件，返回所有发现的问题
+func (t *MachineDefinition) Validate() error {
+	return errors.Join(t.validate(t.Name)...)
+}
+
+func (t *MachineDefinition) validate(path string) (errs []error) {
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: machine=%s %s", ErrInvalidDefinition, path, fmt.Sprintf(format, args...)))
+	}
+
+	if t.Name == "" {
+		invalid("name is empty")
+	}
+
+	states := make(map[State]bool)
+	for _, state := range t.States {
+		if state == "" || state == "Entry" {
+			invalid("state %q is reserved", state)
+		} else if states[state] {
+			invalid("state=%s is declared twice", state)
+		}
+		states[state] = true
+	}
+	if t.CurrentState != "" && t.CurrentState != "Entry" && !states[t.CurrentState] {
+		invalid("currentState=%s is not a declared state", t.CurrentState)
+	}
+
+	parameters := make(map[string]ParameterType)
+	for _, parameter := range t.Parameters {
+		if parameter == nil || parameter.Name == "" {
+			invalid("parameter name is empty")
+			continue
+		}
+		if _, ok := parameters[parameter.Name]; ok {
+			invalid("parameter=%s is declared twice", parameter.Name)
+		}
+		parameters[parameter.Name] = parameter.Type
+		if !validParameterType(parameter.Type) {
+			invalid("parameter=%s has invalid type %q", parameter.Name, parameter.Type)
+		} else if parameter.Value != "" && !validParameterValue(parameter.Type, parameter.Value) {
+			invalid("parameter=%s value %q is not a valid %s", parameter.Name, parameter.Value, parameter.Type)
+		}
+	}
+
+	for _, from := range sortedKeys(t.ValidTransitions) {
+		if !states[from] {
+			invalid("validTransitions fromState=%s is not a declared state", from)
+		}
+		for _, to := range t.ValidTransitions[from] {
+			if !states[to] {
+				invalid("validTransitions fromState=%s toState=%s is not a declared state", from, to)
+			}
+		}
+	}
+
+	transitions := make(map[string]bool)
+	for _, trans := range t.Transitions {
+		if trans == nil {
+			invalid("transition is empty")
+			continue
+		}
+		if trans.Name == "" {
+			invalid("transition name is empty")
+		} else if transitions[trans.Name] {
+			invalid("transition=%s is declared twice", trans.Name)
+		}
+		transitions[trans.Name] = true
+
+		if !states[trans.From] {
+			invalid("transition=%s fromState=%s is not a declared state", trans.Name, trans.From)
+		}
+		if !states[trans.To] {
+			invalid("transition=%s toState=%s is not a declared state", trans.Name, trans.To)
+		}
+		if !slices.Contains(t.ValidTransitions[trans.From], trans.To) {
+			invalid("transition=%s fromState=%s toState=%s was not registered in validTransitions", trans.Name, trans.From, trans.To)
+		}
+		if trans.Event != "" && len(trans.Parameters) > 0 {
+			invalid("transition=%s event=%s can not declare parameters", trans.Name, trans.Event)
+		}
+		for _, name := range trans.Parameters {
+			if _, ok := parameters[name]; !ok {
+				invalid("transition=%s parameter=%s is not declared", trans.Name, name)
+			}
+		}
+		for _, name := range sortedKeys(trans.Conditions) {
+			for _, err := range trans.Conditions[name].validate(parameters) {
+				invalid("transition=%s condition=%s %s", trans.Name, name, err)
+			}
+		}
+	}
+
+	machines := make(map[string]bool)
+	for _, sub := range t.SubMachines {
+		if sub == nil {
+			invalid("sub state machine is empty")
+			continue
+		}
+		if machines[sub.Name] {
+			invalid("sub state machine=%s is declared twice", sub.Name)
+		}
+		machines[sub.Name] = true
+		if sub.ParentState != "" && !states[sub.ParentState] {
+			invalid("sub state machine=%s parentState=%s is not a declared state", sub.Name, sub.ParentState)
+		}
+		if !validHistoryType(sub.History) {
+			invalid("sub state machine=%s invalid history type %q", sub.Name, sub.History)
+		} else if sub.History != HistoryTypeNone && sub.ParentState == "" {
+			invalid("sub state machine=%s history requires parentState", sub.Name)
+		}
+		errs = append(errs, sub.validate(path+"/"+sub.Name)...)
+	}
+	return errs
+}
+
+// validate 校验条件引用的参数、比较方式和比较的值，返回的错误不带路径
+func (t *ConditionDefinition) validate(parameters map[string]ParameterType) (errs []error) {
+	if t == nil {
+		return append(errs, errors.New("condition is empty"))
+	}
+	if t.isGroup() {
+		if t.Parameter != "" || t.Compare != "" || t.Value != "" {
+			errs = append(errs, errors.New("condition group can not have parameter, compare or value"))
+		}
+		if t.Group != "" && t.Group != ConditionGroupCompareTypeAnd && t.Group != ConditionGroupCompareTypeOr {
+			errs = append(errs, fmt.Errorf("invalid group compare type %q", t.Group))
+		}
+		for _, name := range sortedKeys(t.Conditions) {
+			for _, err := range t.Conditions[name].validate(parameters) {
+				errs = append(errs, fmt.Errorf("condition=%s %w", name, err))
+			}
+		}
+		return errs
+	}
+
+	parameterType, ok := parameters[t.Parameter]
+	if !ok {
+		return append(errs, fmt.Errorf("parameter=%s is not declared", t.Parameter))
+	}
+	if !validCompareType(parameterType, t.Compare) {
+		errs = append(errs, fmt.Errorf("invalid compare type %q for %s parameter=%s", t.Compare, parameterType, t.Parameter))
+	}
+	if validParameterType(parameterType) && !validParameterValue(parameterType, t.Value) {
+		errs = append(errs, fmt.Errorf("value %q is not a valid %s", t.Value, parameterType))
+	}
+	return errs
+}
+
+func validParameterType(parameterType ParameterType) bool {
+	switch parameterType {
+	case ParameterTypeBool, ParameterTypeString, ParameterTypeFloat, ParameterTypeInt:
+		return true
+	}
+	return false
+}
+
+// validParameterValue 检查值能否按照参数类型解析，与 Condition 比较时的解析方式一致
+func validParameterValue(parameterType ParameterType, value string) bool {
+	var err error
+	switch parameterType {
+	case ParameterTypeBool:
+		_, err = strconv.ParseBool(value)
+	case ParameterTypeFloat:
+		_, err = strconv.ParseFloat(value, 32)
+	case ParameterTypeInt:
+		_, err = strconv.Atoi(value)
+	}
+	return err == nil
+}
+
+// validCompareType bool 和 string 类型的参数只能比较是否相等
+func validCompareType(parameterType ParameterType, compareType CompareType) bool {
+	switch compareType {
+	case CompareTypeEqual, CompareTypeNotEqual:
+		return true
+	case CompareTypeLess, CompareTypeLessEuqal, CompareTypeGreater, CompareTypeGreaterEqual:
+		return parameterType == ParameterTypeFloat || parameterType == ParameterTypeInt
+	}
+	return false
+}
+
+// build 按照已经校验过的定义生成状态机
+func (t *MachineDefinition) build() (sm *StateMachine) {
+	sm = NewStateMachine(t.Name)
+	sm.ParentState = t.ParentState
+	sm.History = t.History
+	sm.addState(t.States...)
+	for _, from := range sortedKeys(t.ValidTransitions) {
+		sm.addValidTransition(from, t.ValidTransitions[from])
+	}
+	if t.CurrentState != "" {
+		sm.CurrentState = t.CurrentState
+	}
+
+	for _, def := range t.Parameters {
+		sm.Parameters[def.Name] = &Parameter{Name: def.Name, Type: def.Type, Value: def.Value}
+	}
+
+	for _, def := range t.Transitions {
+		trans := &Transition{Name: def.Name, From: def.From, To: def.To, Event: def.Event, Conditions: make(map[string]ICondition)}
+		var referenced []string
+		for name, condition := range def.Conditions {
+			trans.Conditions[name] = condition.build()
+			referenced = append(referenced, condition.parameters()...)
+		}
+		parameters := def.Parameters
+		if len(parameters) < 1 {
+			parameters = referenced
+		}
+		sm.Transitions[def.Name] = trans
+		if trans.Event != "" {
+			continue
+		}
+		for _, name := range uniqueSorted(parameters) {
+			parameter := sm.Parameters[name]
+			sm.ParametersLink[parameter] = append(sm.ParametersLink[parameter], trans)
+		}
+	}
+
+	for _, def := range t.SubMachines {
+		sub := def.build()
+		// 与 BindSubMachine 相同，只有绑定的子状态机才需要父状态机
+		if sub.ParentState != "" {
+			sub.parent = sm
+		}
+		sm.SubMachines[def.Name] = sub
+	}
+	return sm
+}
+
+func (t *ConditionDefinition) build() ICondition {
+	if !t.isGroup() {
+		return &Condition{ParameterName: t.Parameter, CompareType: t.Compare, Value: t.Value}
+	}
+	group := &ConditionGroup{CompareType: t.Group, Conditions: make(map[string]ICondition)}
+	if group.CompareType == "" {
+		group.CompareType = ConditionGroupCompareTypeAnd
+	}
+	for name, condition := range t.Conditions {
+		group.Conditions[name] = condition.build()
+	}
+	return group
+}
+
+// parameters 返回条件中引用的所有参数
+func (t *ConditionDefinition) parameters() (names []string) {
+	if !t.isGroup() {
+		return []string{t.Parameter}
+	}
+	for _, condition := range t.Conditions {
+		names = append(names, condition.parameters()...)
+	}
+	return names
+}
+
+// Definition 导出状态机及其子状态机的定义，只支持 Condition 和 ConditionGroup 两种条件
+func (t *StateMachine) Definition() (def *MachineDefinition, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	def = &MachineDefinition{Name: t.Name, States: slices.Clone(t.States), ParentState: t.ParentState, History: t.History}
+	if t.CurrentState != "Entry" {
+		def.CurrentState = t.CurrentState
+	}
+
+	names := make(map[*Parameter]string)
+	for _, name := range sortedKeys(t.Parameters) {
+		parameter := t.Parameters[name]
+		names[parameter] = name
+		def.Parameters = append(def.Parameters, &ParameterDefinition{Name: name, Type: parameter.Type, Value: parameter.Value})
+	}
+
+	for _, from := range sortedKeys(t.ValidTransition) {
+		if def.ValidTransitions == nil {
+			def.ValidTransitions = make(map[State][]State)
+		}
+		// addValidTransition 创建的列表第一个元素为空
+		def.ValidTransitions[from] = slices.DeleteFunc(slices.Clone(t.ValidTransition[from]), func(state State) bool { return state == "" })
+	}
+
+	for _, name := range sortedKeys(t.Transitions) {
+		trans := t.Transitions[name]
+		transDef := &TransitionDefinition{Name: name, From: trans.From, To: trans.To, Event: trans.Event}
+		var referenced []string
+		for conditionName, condition := range trans.Conditions {
+			if transDef.Conditions == nil {
+				transDef.Conditions = make(map[string]*ConditionDefinition)
+			}
+			if transDef.Conditions[conditionName], err = exportCondition(condition); err != nil {
+				return nil, fmt.Errorf("machine=%s transition=%s condition=%s %w", t.Name, name, conditionName, err)
+			}
+			referenced = append(referenced, transDef.Conditions[conditionName].parameters()...)
+		}
+
+		var linked []string
+		for parameter, transitions := range t.ParametersLink {
+			if name, ok := names[parameter]; ok && slices.Contains(transitions, trans) {
+				linked = append(linked, name)
+			}
+		}
+		// 与条件中引用的参数相同时省略，加载时会自动推导
+		if linked = uniqueSorted(linked); trans.Event == "" && !slices.Equal(linked, uniqueSorted(referenced)) {
+			transDef.Parameters = linked
+		}
+		def.Transitions = append(def.Transitions, transDef)
+	}
+
+	for _, name := range sortedKeys(t.SubMachines) {
+		sub, err := t.SubMachines[name].Definition()
+		if err != nil {
+			return nil, err
+		}
+		def.SubMachines = append(def.SubMachines, sub)
+	}
+	return def, nil
+}
+
+func exportCondition(condition ICondition) (def *ConditionDefinition, err error) {
+	switch c := condition.(type) {
+	case *Condition:
+		return &ConditionDefinition{Parameter: c.ParameterName, Compare: c.CompareType, Value: c.Value}, nil
+	case *ConditionGroup:
+		def = &ConditionDefinition{Group: c.CompareType, Conditions: make(map[string]*ConditionDefinition)}
+		if def.Group == "" {
+			def.Group = ConditionGroupCompareTypeAnd
+		}
+		for name, sub := range c.Conditions {
+			if def.Conditions[name], err = exportCondition(sub); err != nil {
+				return nil, fmt.Errorf("condition=%s %w", name, err)
+			}
+		}
+		return def, nil
+	}
+	return nil, fmt.Errorf("condition type %T can not be exported", condition)
+}
+
+// ExportYAML 以 YAML 格式导出状态机定义
+func (t *StateMachine) ExportYAML() (data []byte, err error) {
+	def, err := t.Definition()
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(def)
+}
+
+// ExportJSON 以 JSON 格式导出状态机定义
+func (t *StateMachine) ExportJSON() (data []byte, err error) {
+	def, err := t.Definition()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(def, "", "  ")
+}
+
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func uniqueSorted(values []string) []string {
+	values = slices.Clone(values)
+	sort.Strings(values)
+	return slices.Compact(values)
+}
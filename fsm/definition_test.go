@@ -0,0 +1,156 @@
+package fsm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const playerDefinition = `
+name: Player
+states: [idle, walk, run]
+parameters:
+  - name: speed
+    type: float
+    value: 0
+  - name: grounded
+    type: bool
+    value: true
+validTransitions:
+  idle: [walk, run]
+  walk: [idle, run]
+  run: [walk]
+transitions:
+  - name: idle_walk
+    from: idle
+    to: walk
+    conditions:
+      moving:
+        group: and
+        conditions:
+          faster:
+            parameter: speed
+            compare: ">"
+            value: 0
+          slower:
+            parameter: speed
+            compare: "<"
+            value: 5
+  - name: walk_run
+    from: walk
+    to: run
+    conditions:
+      fast:
+        parameter: speed
+        compare: ">"
+        value: 5
+subMachines:
+  - name: Motion
+    states: [ground, fly]
+    validTransitions:
+      ground: [fly]
+`
+
+func TestLoadStateMachine(t *testing.T) {
+	sm, err := LoadStateMachine([]byte(playerDefinition))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []State{"idle", "walk", "run"}, sm.States)
+	assert.Equal(t, "0", sm.GetParameter("speed").Value)
+	assert.Equal(t, "Motion", sm.GetMachine("/Player/Motion").Name)
+	assert.Equal(t, 2, len(sm.ParametersLink[sm.GetParameter("speed")]))
+
+	sm.AutoTransit()
+	assert.Equal(t, "idle", sm.GetCurrentState())
+	sm.SetParameterValue("speed", "4.9")
+	assert.Equal(t, "walk", sm.GetCurrentState())
+	sm.SetParameterValue("speed", "10")
+	assert.Equal(t, "run", sm.GetCurrentState())
+
+	// 导出后再加载得到相同的定义
+	data, err := sm.ExportYAML()
+	assert.Equal(t, nil, err)
+	loaded, err := LoadStateMachine(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "run", loaded.GetCurrentState())
+	def, _ := sm.Definition()
+	loadedDef, _ := loaded.Definition()
+	assert.Equal(t, def, loadedDef)
+
+	data, err = sm.ExportJSON()
+	assert.Equal(t, nil, err)
+	loaded, err = LoadStateMachine(data)
+	assert.Equal(t, nil, err)
+	loadedDef, _ = loaded.Definition()
+	assert.Equal(t, def, loadedDef)
+}
+
+func TestDefinitionExportManual(t *testing.T) {
+	sm := NewStateMachine("APP")
+	sm.AddValidTransition("idle", []State{"walk"})
+	speed := &Parameter{Name: "speed", Value: "0", Type: ParameterTypeInt}
+	sm.AddParameter(speed)
+	sm.AddParameter(&Parameter{Name: "trigger", Value: "false", Type: ParameterTypeBool})
+	sm.AddAutoTransition(&Transition{Name: "idle_walk", From: "idle", To: "walk", Conditions: map[string]ICondition{
+		"fast": &Condition{CompareType: CompareTypeGreaterEqual, ParameterName: "speed", Value: "1"},
+	}}, sm.GetParameter("trigger"))
+
+	def, err := sm.Definition()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[State][]State{"idle": {"walk"}}, def.ValidTransitions)
+	// 关联的参数与条件中引用的参数不同，需要导出
+	assert.Equal(t, []string{"trigger"}, def.Transitions[0].Parameters)
+
+	sm.RemoveAutoTransition("idle_walk")
+	assert.Equal(t, 0, len(sm.ParametersLink))
+
+	sm.Transitions["custom"] = &Transition{Name: "custom", From: "idle", To: "walk", Conditions: map[string]ICondition{
+		"func": conditionFunc(func(parameters map[string]*Parameter) bool { return true }),
+	}}
+	_, err = sm.ExportYAML()
+	assert.Equal(t, true, strings.Contains(err.Error(), "can not be exported"))
+}
+
+type conditionFunc func(parameters map[string]*Parameter) bool
+
+func (t conditionFunc) Compare(parameters map[string]*Parameter) bool {
+	return t(parameters)
+}
+
+func TestDefinitionValidate(t *testing.T) {
+	_, err := LoadStateMachine([]byte(`{
+  "name": "APP",
+  "states": ["idle", "walk"],
+  "currentState": "fly",
+  "parameters": [{"name": "speed", "type": "float"}, {"name": "name", "type": "string"}, {"name": "hp", "type": "long"}],
+  "validTransitions": {"idle": ["walk", "run"]},
+  "transitions": [
+    {"name": "walk_idle", "from": "walk", "to": "idle"},
+    {"name": "idle_walk", "from": "idle", "to": "walk", "conditions": {
+      "a": {"parameter": "missing", "compare": "==", "value": "1"},
+      "b": {"parameter": "name", "compare": ">", "value": "bob"},
+      "c": {"group": "xor", "conditions": {"d": {"parameter": "speed", "compare": "~", "value": "fast"}}}
+    }}
+  ],
+  "subMachines": [{"name": "Sub", "states": ["a"], "transitions": [{"name": "a_b", "from": "a", "to": "b"}]}]
+}`))
+	assert.Equal(t, true, errors.Is(err, ErrInvalidDefinition))
+	for _, message := range []string{
+		"currentState=fly is not a declared state",
+		`parameter=hp has invalid type "long"`,
+		"validTransitions fromState=idle toState=run is not a declared state",
+		"transition=walk_idle fromState=walk toState=idle was not registered in validTransitions",
+		"condition=a parameter=missing is not declared",
+		`condition=b invalid compare type ">" for string parameter=name`,
+		`condition=c invalid group compare type "xor"`,
+		`condition=c condition=d invalid compare type "~"`,
+		`condition=c condition=d value "fast" is not a valid float`,
+		"machine=APP/Sub transition=a_b toState=b is not a declared state",
+	} {
+		assert.Contains(t, err.Error(), message)
+	}
+
+	_, err = LoadStateMachine([]byte("name: APP\nstate: [idle]\n"))
+	assert.NotEqual(t, nil, err)
+}
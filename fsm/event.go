@@ -0,0 +1,201 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnknownEvent 状态机中没有任何转换处理这个事件
+	ErrUnknownEvent = errors.New("unknown event")
+	// ErrEventNotAllowed 当前状态没有处理这个事件的转换
+	ErrEventNotAllowed = errors.New("event not allowed in current state")
+	// ErrConditionsNotMet 处理这个事件的转换的条件都不满足
+	ErrConditionsNotMet = errors.New("transition conditions not met")
+)
+
+// EventError Fire 没有执行任何转换时返回的错误，Err 为 ErrUnknownEvent、ErrEventNotAllowed，
+// 或者由每个候选转换的 ErrConditionsNotMet 和 *GuardError 组成的错误
+type EventError struct {
+	Event string
+	// State 收到事件时的状态
+	State State
+	Err   error
+}
+
+func (t *EventError) Error() string {
+	return fmt.Sprintf("event=%s state=%s: %s", t.Event, t.State, t.Err)
+}
+
+func (t *EventError) Unwrap() error {
+	return t.Err
+}
+
+var (
+	// eventTransitionPattern 匹配 from --event[guard]--> to
+	eventTransitionPattern = regexp.MustCompile(`^\s*(\S+)\s*--\s*([^\[\]]+?)\s*(?:\[(.*)\])?\s*-->\s*(\S+)\s*$`)
+	// guardConditionPattern 匹配 parameter op value
+	guardConditionPattern = regexp.MustCompile(`^\s*([^\s=!<>]+)\s*(==|!=|<=|>=|<|>)\s*(.*?)\s*$`)
+)
+
+// AddEventTransition 添加由事件触发的转换，spec 形如 idle --player_joined[players >= 2 and ready == true]--> lobby，
+// 守卫条件可以省略，条件之间可以用 and 或者 or 连接但不能混用，引用的参数必须已经添加。
+// 事件转换不会因为参数变化或者 AutoTransit 而触发，只能通过 Fire 触发，From 和 To 会自动登记为合法的状态切换。
+// 返回的转换可以继续设置 Guards 和 Actions
+func (t *StateMachine) AddEventTransition(spec string) (trans *Transition, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	match := eventTransitionPattern.FindStringSubmatch(spec)
+	if match == nil {
+		return nil, fmt.Errorf("invalid event transition %q, expected from --event[guard]--> to", spec)
+	}
+	trans = &Transition{Name: match[1] + " --" + match[2] + "--> " + match[4], From: match[1], To: match[4], Event: match[2]}
+	if trans.Conditions, err = t.parseGuardExpression(match[3]); err != nil {
+		return nil, fmt.Errorf("event transition %q %w", spec, err)
+	}
+	if err = t.addEventTransition(trans); err != nil {
+		return nil, err
+	}
+	return trans, nil
+}
+
+// AddTransition 添加转换，Event 为空时与不关联参数的 AddAutoTransition 相同，只能由 AutoTransit 和 SetState 触发。
+// Event 不为空时只能由 Fire 触发，From 和 To 会自动登记为合法的状态切换
+func (t *StateMachine) AddTransition(trans *Transition) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if trans.Event != "" {
+		return t.addEventTransition(trans)
+	}
+	if !t.checkTransitionValid(trans.From, trans.To) {
+		return fmt.Errorf("transition=%s fromState=%s toState=%s was not registered in valid transition set", trans.Name, trans.From, trans.To)
+	}
+	if _, ok := t.Transitions[trans.Name]; ok {
+		return fmt.Errorf("transition=%s already exists", trans.Name)
+	}
+	t.Transitions[trans.Name] = trans
+	return
+}
+
+func (t *StateMachine) addEventTransition(trans *Transition) (err error) {
+	if _, ok := t.Transitions[trans.Name]; ok {
+		return fmt.Errorf("transition=%s already exists", trans.Name)
+	}
+	t.addValidTransition(trans.From, []State{trans.To})
+	t.Transitions[trans.Name] = trans
+	return
+}
+
+// parseGuardExpression 将 a > 1 and b == true 形式的守卫条件解析为转换的条件，or 连接的每一项是一个条件，满足其中一个即可
+func (t *StateMachine) parseGuardExpression(expr string) (conditions map[string]ICondition, err error) {
+	expr = strings.TrimSpace(expr)
+	for strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	}
+	if expr == "" {
+		return nil, nil
+	}
+
+	separator, compareType := " and ", ConditionGroupCompareTypeAnd
+	if strings.Contains(expr, " or ") {
+		if strings.Contains(expr, " and ") {
+			return nil, fmt.Errorf("guard %q can not mix and with or", expr)
+		}
+		separator, compareType = " or ", ConditionGroupCompareTypeOr
+	}
+
+	conditions = make(map[string]ICondition)
+	group := &ConditionGroup{CompareType: compareType, Conditions: make(map[string]ICondition)}
+	for i, part := range strings.Split(expr, separator) {
+		condition, err := t.parseGuardCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		name := "guard" + strconv.Itoa(i)
+		if compareType == ConditionGroupCompareTypeOr {
+			conditions[name] = condition
+		} else {
+			group.Conditions[name] = condition
+		}
+	}
+	if len(group.Conditions) == 1 {
+		conditions["guard0"] = group.Conditions["guard0"]
+	} else if len(group.Conditions) > 1 {
+		conditions["guard"] = group
+	}
+	return conditions, nil
+}
+
+// parseGuardCondition 解析 parameter op value 形式的单个条件，并按照参数类型检查比较方式和值
+func (t *StateMachine) parseGuardCondition(expr string) (condition *Condition, err error) {
+	match := guardConditionPattern.FindStringSubmatch(strings.Trim(strings.TrimSpace(expr), "()"))
+	if match == nil {
+		return nil, fmt.Errorf("invalid guard condition %q, expected parameter op value", expr)
+	}
+	condition = &Condition{ParameterName: match[1], CompareType: match[2], Value: strings.Trim(match[3], `"'`)}
+
+	parameter, ok := t.Parameters[condition.ParameterName]
+	if !ok {
+		return nil, fmt.Errorf("guard condition %q parameter=%s is not declared", expr, condition.ParameterName)
+	}
+	if !validCompareType(parameter.Type, condition.CompareType) {
+		return nil, fmt.Errorf("guard condition %q invalid compare type %q for %s parameter=%s", expr, condition.CompareType, parameter.Type, parameter.Name)
+	}
+	if !validParameterValue(parameter.Type, condition.Value) {
+		return nil, fmt.Errorf("guard condition %q value %q is not a valid %s", expr, condition.Value, parameter.Type)
+	}
+	return condition, nil
+}
+
+// Fire 触发事件，按照名称顺序检查从当前状态出发并处理这个事件的转换，执行第一个条件满足并且没有被守卫函数拒绝的转换并返回它，
+// 没有执行任何转换时返回 *EventError。payload 会通过 TransitionContext.Payload 传递给守卫函数和动作。
+// 事件先交给绑定到当前状态的子状态机处理，最后才交给处于绑定状态的父状态机处理，都没有处理时返回每一层的 *EventError
+func (t *StateMachine) Fire(event string, payload any) (trans *Transition, err error) {
+	t.lock.Lock()
+	trans, err = t.dispatch(event, payload)
+	t.lock.Unlock()
+	if err == nil {
+		return trans, nil
+	}
+	return t.bubble(event, payload, err)
+}
+
+func (t *StateMachine) fire(event string, payload any) (trans *Transition, err error) {
+	known := false
+	var errs []error
+	for _, candidate := range t.sortedTransitions() {
+		if candidate.Event != event {
+			continue
+		}
+		known = true
+		if candidate.From != t.CurrentState {
+			continue
+		}
+		if candidate.Transit(t.Parameters) == "" {
+			errs = append(errs, fmt.Errorf("%w: transition=%s", ErrConditionsNotMet, candidate.Name))
+			continue
+		}
+		ctx := &TransitionContext{Machine: t, Transition: candidate, From: t.CurrentState, To: candidate.To, Parameters: t.Parameters, Event: event, Payload: payload}
+		if err = t.transit(ctx); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return candidate, nil
+	}
+
+	eventErr := &EventError{Event: event, State: t.CurrentState}
+	switch {
+	case !known:
+		eventErr.Err = ErrUnknownEvent
+	case len(errs) < 1:
+		eventErr.Err = ErrEventNotAllowed
+	default:
+		eventErr.Err = errors.Join(errs...)
+	}
+	return nil, eventErr
+}
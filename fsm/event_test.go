@@ -0,0 +1,121 @@
+package fsm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLobby(t *testing.T) *StateMachine {
+	sm := NewStateMachine("Lobby")
+	sm.AddParameter(&Parameter{Name: "players", Value: "0", Type: ParameterTypeInt})
+	sm.AddParameter(&Parameter{Name: "ready", Value: "false", Type: ParameterTypeBool})
+	for _, spec := range []string{
+		"waiting --player_joined[players >= 2 and ready == true]--> playing",
+		"waiting --player_joined--> waiting",
+		"playing --timeout--> finished",
+		"playing --player_left[players < 2 or ready == false]--> waiting",
+	} {
+		_, err := sm.AddEventTransition(spec)
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, sm.AutoTransit())
+	assert.Equal(t, "waiting", sm.GetCurrentState())
+	return sm
+}
+
+func TestFire(t *testing.T) {
+	sm := newLobby(t)
+
+	var payloads []any
+	sm.OnEnter("playing", func(ctx *TransitionContext) {
+		payloads = append(payloads, ctx.Event, ctx.Payload)
+	})
+
+	// 条件不满足时执行下一个转换
+	trans, err := sm.Fire("player_joined", "alice")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "waiting --player_joined--> waiting", trans.Name)
+	assert.Equal(t, "waiting", sm.GetCurrentState())
+
+	// 事件转换不会因为参数变化而触发
+	sm.SetParameterValue("players", "2")
+	sm.SetParameterValue("ready", "true")
+	assert.Equal(t, "waiting", sm.GetCurrentState())
+
+	trans, err = sm.Fire("player_joined", "bob")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "playing", trans.To)
+	assert.Equal(t, []any{"player_joined", "bob"}, payloads)
+
+	// 条件不满足
+	_, err = sm.Fire("player_left", nil)
+	var eventErr *EventError
+	assert.Equal(t, true, errors.As(err, &eventErr))
+	assert.Equal(t, "player_left", eventErr.Event)
+	assert.Equal(t, "playing", eventErr.State)
+	assert.Equal(t, true, errors.Is(err, ErrConditionsNotMet))
+
+	// 当前状态不处理这个事件
+	_, err = sm.Fire("player_joined", nil)
+	assert.Equal(t, true, errors.Is(err, ErrEventNotAllowed))
+
+	// 没有任何转换处理这个事件
+	_, err = sm.Fire("explode", nil)
+	assert.Equal(t, true, errors.Is(err, ErrUnknownEvent))
+
+	// 守卫函数拒绝
+	paused := errors.New("paused")
+	sm.Transitions["playing --timeout--> finished"].Guards = []Guard{func(ctx *TransitionContext) error {
+		if ctx.Payload == "paused" {
+			return paused
+		}
+		return nil
+	}}
+	_, err = sm.Fire("timeout", "paused")
+	assert.Equal(t, true, errors.Is(err, ErrTransitionRejected))
+	assert.Equal(t, true, errors.Is(err, paused))
+	assert.Equal(t, "playing", sm.GetCurrentState())
+	_, err = sm.Fire("timeout", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "finished", sm.GetCurrentState())
+}
+
+func TestAddEventTransitionInvalid(t *testing.T) {
+	sm := newLobby(t)
+	for spec, message := range map[string]string{
+		"waiting -> playing":                                                          "expected from --event[guard]--> to",
+		"waiting --start[score > 1]--> playing":                                       "parameter=score is not declared",
+		"waiting --start[ready > true]--> playing":                                    `invalid compare type ">"`,
+		"waiting --start[players == many]--> playing":                                 `value "many" is not a valid int`,
+		"waiting --start[players > 1 and ready == true or ready == false]--> playing": "can not mix and with or",
+		"waiting --player_joined--> waiting":                                          "already exists",
+	} {
+		_, err := sm.AddEventTransition(spec)
+		assert.Equal(t, true, err != nil && strings.Contains(err.Error(), message), spec)
+	}
+}
+
+func TestEventDefinition(t *testing.T) {
+	sm := newLobby(t)
+	data, err := sm.ExportYAML()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(data), "event: player_joined"))
+
+	loaded, err := LoadStateMachine(data)
+	assert.Equal(t, nil, err)
+	def, _ := sm.Definition()
+	loadedDef, _ := loaded.Definition()
+	assert.Equal(t, def, loadedDef)
+	assert.Equal(t, 0, len(loaded.ParametersLink))
+
+	loaded.SetParameterValue("players", "3")
+	loaded.SetParameterValue("ready", "true")
+	_, err = loaded.Fire("player_joined", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "playing", loaded.GetCurrentState())
+
+	assert.Equal(t, true, strings.Contains(sm.ExportMermaid(), "s0 --> s1 : player_joined [(players >= 2 and ready == true)]"))
+}
@@ -0,0 +1,171 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// HistoryType 子状态机重新启动时如何恢复状态
+type HistoryType = string
+
+const (
+	// HistoryTypeNone 每次都从第一个状态启动
+	HistoryTypeNone HistoryType = ""
+	// HistoryTypeShallow 恢复到上次停止时的状态，它的子状态机按照各自的 History 启动
+	HistoryTypeShallow HistoryType = "shallow"
+	// HistoryTypeDeep 恢复到上次停止时的状态，它的所有子状态机也恢复到上次停止时的状态
+	HistoryTypeDeep HistoryType = "deep"
+)
+
+func validHistoryType(history HistoryType) bool {
+	switch history {
+	case HistoryTypeNone, HistoryTypeShallow, HistoryTypeDeep:
+		return true
+	}
+	return false
+}
+
+// BindSubMachine 将名为 name 的子状态机绑定到 state，父状态机进入 state 时子状态机从 Entry 启动，离开 state 时子状态机回到 Entry。
+// 绑定后子状态机的 Fire 没有执行任何转换时会交给处于 state 的父状态机处理。如果父状态机当前就处于 state，子状态机会立即启动，
+// 否则正在运行的子状态机会停止并记录历史
+func (t *StateMachine) BindSubMachine(name string, state State, history HistoryType) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	sub, ok := t.SubMachines[name]
+	if !ok {
+		return fmt.Errorf("sub state machine=%s not found", name)
+	}
+	if !slices.Contains(t.States, state) {
+		return fmt.Errorf("sub state machine=%s state=%s is not a state of machine=%s", name, state, t.Name)
+	}
+	if !validHistoryType(history) {
+		return fmt.Errorf("sub state machine=%s invalid history type %q", name, history)
+	}
+
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	sub.parent = t
+	sub.ParentState = state
+	sub.History = history
+	if t.CurrentState == state {
+		sub.start(false)
+	} else {
+		// 父状态机不在新绑定的状态时停止子状态机并记录历史，等父状态机进入 state 时再启动
+		sub.stop()
+	}
+	return
+}
+
+// boundSubMachines 按照名称顺序返回绑定到 state 的子状态机
+func (t *StateMachine) boundSubMachines(state State) (machines []*StateMachine) {
+	for _, name := range sortedKeys(t.SubMachines) {
+		if sub := t.SubMachines[name]; sub.ParentState != "" && sub.ParentState == state {
+			machines = append(machines, sub)
+		}
+	}
+	return machines
+}
+
+// leave 离开 ctx.From，先停止绑定到 From 的子状态机，再执行离开 From 的动作
+func (t *StateMachine) leave(ctx *TransitionContext) {
+	for _, sub := range t.boundSubMachines(ctx.From) {
+		sub.lock.Lock()
+		sub.stop()
+		sub.lock.Unlock()
+	}
+	for _, action := range t.exitActions[ctx.From] {
+		action(ctx)
+	}
+}
+
+// enter 进入 ctx.To，依次执行进入 To 的动作、启动绑定到 To 的子状态机、状态切换回调，deep 为 true 时子状态机按照深历史恢复
+func (t *StateMachine) enter(ctx *TransitionContext, deep bool) {
+	t.CurrentState = ctx.To
+	for _, action := range t.enterActions[ctx.To] {
+		action(ctx)
+	}
+	for _, sub := range t.boundSubMachines(ctx.To) {
+		sub.lock.Lock()
+		sub.start(deep)
+		sub.lock.Unlock()
+	}
+	if t.callback != nil {
+		t.callback(ctx.From, ctx.To)
+	}
+}
+
+// start 从 Entry 启动，有历史记录并且设置了 History 或者 deep 为 true 时恢复到上次停止时的状态，否则进入第一个状态。
+// 启动不经过守卫函数，调用方需要持有 t.lock
+func (t *StateMachine) start(deep bool) {
+	if t.CurrentState != "Entry" || len(t.States) < 1 {
+		return
+	}
+	to := t.States[0]
+	if (deep || t.History != HistoryTypeNone) && t.lastState != "" {
+		to = t.lastState
+	}
+	t.enter(&TransitionContext{Machine: t, From: t.CurrentState, To: to, Parameters: t.Parameters}, deep || t.History == HistoryTypeDeep)
+}
+
+// stop 记录当前状态用于历史恢复并回到 Entry，停止不经过守卫函数，调用方需要持有 t.lock
+func (t *StateMachine) stop() {
+	if t.CurrentState == "Entry" {
+		return
+	}
+	ctx := &TransitionContext{Machine: t, From: t.CurrentState, To: "Entry", Parameters: t.Parameters}
+	t.lastState = t.CurrentState
+	t.leave(ctx)
+	t.CurrentState = ctx.To
+	if t.callback != nil {
+		t.callback(ctx.From, ctx.To)
+	}
+}
+
+// dispatch 先把事件交给处于运行中的绑定子状态机，都没有执行转换时再由 t 处理，调用方需要持有 t.lock
+func (t *StateMachine) dispatch(event string, payload any) (trans *Transition, err error) {
+	var errs []error
+	for _, sub := range t.boundSubMachines(t.CurrentState) {
+		sub.lock.Lock()
+		trans, err = sub.dispatch(event, payload)
+		sub.lock.Unlock()
+		if err == nil {
+			return trans, nil
+		}
+		errs = append(errs, err)
+	}
+
+	if trans, err = t.fire(event, payload); err == nil {
+		return trans, nil
+	}
+	if len(errs) < 1 {
+		return nil, err
+	}
+	return nil, errors.Join(append(errs, err)...)
+}
+
+// bubble 子状态机没有执行任何转换时，依次交给处于绑定状态的父状态机处理，调用方不能持有任何状态机的锁
+func (t *StateMachine) bubble(event string, payload any, err error) (trans *Transition, _ error) {
+	t.lock.RLock()
+	parent, state := t.parent, t.ParentState
+	t.lock.RUnlock()
+
+	for parent != nil && state != "" {
+		parent.lock.Lock()
+		if parent.CurrentState != state {
+			parent.lock.Unlock()
+			break
+		}
+		var parentErr error
+		trans, parentErr = parent.fire(event, payload)
+		next, nextState := parent.parent, parent.ParentState
+		parent.lock.Unlock()
+		if parentErr == nil {
+			return trans, nil
+		}
+		err = errors.Join(err, parentErr)
+		parent, state = next, nextState
+	}
+	return nil, err
+}
@@ -0,0 +1,140 @@
+package fsm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T) (app, game, match *StateMachine) {
+	app = NewStateMachine("App")
+	game = NewStateMachine("Game")
+	match = NewStateMachine("Match")
+	for sm, specs := range map[*StateMachine][]string{
+		app:   {"menu --play--> game", "game --quit--> menu"},
+		game:  {"lobby --start--> match", "match --pause--> paused", "paused --resume--> match"},
+		match: {"round1 --next--> round2"},
+	} {
+		for _, spec := range specs {
+			_, err := sm.AddEventTransition(spec)
+			assert.Equal(t, nil, err)
+		}
+	}
+	assert.Equal(t, nil, app.AddSubMachine(game))
+	assert.Equal(t, nil, game.AddSubMachine(match))
+	assert.Equal(t, nil, app.BindSubMachine("Game", "game", HistoryTypeShallow))
+	assert.Equal(t, nil, game.BindSubMachine("Match", "match", HistoryTypeNone))
+	return app, game, match
+}
+
+func TestHierarchicalStateMachine(t *testing.T) {
+	app, game, match := newApp(t)
+
+	var calls []string
+	for _, sm := range []*StateMachine{app, game, match} {
+		name := sm.Name
+		sm.SetStateUpdatedCallback(func(from, to State) {
+			calls = append(calls, name+":"+from+"->"+to)
+		})
+	}
+
+	assert.Equal(t, nil, app.AutoTransit())
+	assert.Equal(t, "menu", app.GetCurrentState())
+	assert.Equal(t, "Entry", game.GetCurrentState())
+
+	// 进入 game 时启动 Game
+	_, err := app.Fire("play", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "lobby", game.GetCurrentState())
+	assert.Equal(t, "Entry", match.GetCurrentState())
+
+	// 事件先交给最内层运行中的子状态机
+	trans, err := app.Fire("start", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "lobby --start--> match", trans.Name)
+	assert.Equal(t, "round1", match.GetCurrentState())
+	_, err = app.Fire("next", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "round2", match.GetCurrentState())
+
+	// 子状态机没有处理的事件交给父状态机，离开 game 时从内到外停止子状态机
+	calls = nil
+	trans, err = match.Fire("quit", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "game --quit--> menu", trans.Name)
+	assert.Equal(t, []string{"Match:round2->Entry", "Game:match->Entry", "App:game->menu"}, calls)
+	assert.Equal(t, "Entry", game.GetCurrentState())
+	assert.Equal(t, "Entry", match.GetCurrentState())
+
+	// Game 为浅历史，Match 没有历史
+	_, err = app.Fire("play", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "match", game.GetCurrentState())
+	assert.Equal(t, "round1", match.GetCurrentState())
+
+	// 深历史时 Match 也会恢复
+	assert.Equal(t, nil, app.BindSubMachine("Game", "game", HistoryTypeDeep))
+	match.Fire("next", nil)
+	app.Fire("quit", nil)
+	app.Fire("play", nil)
+	assert.Equal(t, "match", game.GetCurrentState())
+	assert.Equal(t, "round2", match.GetCurrentState())
+
+	// 每一层都没有处理时返回每一层的错误
+	_, err = match.Fire("explode", nil)
+	assert.Equal(t, true, errors.Is(err, ErrUnknownEvent))
+	var eventErr *EventError
+	assert.Equal(t, true, errors.As(err, &eventErr))
+	assert.Equal(t, "round2", eventErr.State)
+	assert.Equal(t, true, strings.Contains(err.Error(), "state=game"))
+}
+
+func TestBindSubMachine(t *testing.T) {
+	app, game, _ := newApp(t)
+	assert.NotEqual(t, nil, app.BindSubMachine("Nothing", "game", HistoryTypeNone))
+	assert.NotEqual(t, nil, app.BindSubMachine("Game", "nowhere", HistoryTypeNone))
+	assert.NotEqual(t, nil, app.BindSubMachine("Game", "game", "forever"))
+
+	// 父状态机已经处于绑定状态时立即启动
+	app.AutoTransit()
+	app.SetState("game")
+	assert.Equal(t, "lobby", game.GetCurrentState())
+
+	// 重新绑定到父状态机不在的状态时停止并记录历史，进入新的状态时再启动
+	app.Fire("start", nil)
+	assert.Equal(t, "match", game.GetCurrentState())
+	assert.Equal(t, nil, app.BindSubMachine("Game", "menu", HistoryTypeShallow))
+	assert.Equal(t, "Entry", game.GetCurrentState())
+	app.Fire("quit", nil)
+	assert.Equal(t, "menu", app.GetCurrentState())
+	assert.Equal(t, "match", game.GetCurrentState())
+	app.Fire("play", nil)
+	assert.Equal(t, "Entry", game.GetCurrentState())
+}
+
+func TestHierarchyDefinition(t *testing.T) {
+	app, _, _ := newApp(t)
+	data, err := app.ExportYAML()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(data), "parentState: game"))
+	assert.Equal(t, true, strings.Contains(string(data), "history: shallow"))
+
+	loaded, err := LoadStateMachine(data)
+	assert.Equal(t, nil, err)
+	def, _ := app.Definition()
+	loadedDef, _ := loaded.Definition()
+	assert.Equal(t, def, loadedDef)
+	loaded.AutoTransit()
+	loaded.Fire("play", nil)
+	loaded.Fire("start", nil)
+	assert.Equal(t, "round1", loaded.GetMachine("/App/Game/Match").GetCurrentState())
+
+	_, err = LoadStateMachine([]byte("name: App\nstates: [menu]\nsubMachines:\n  - name: Game\n    history: deep\n"))
+	assert.Equal(t, true, errors.Is(err, ErrInvalidDefinition))
+	assert.Equal(t, true, strings.Contains(err.Error(), "history requires parentState"))
+
+	assert.Equal(t, true, strings.Contains(app.ExportDOT(), `label="Game (game, H)";`))
+	assert.Equal(t, true, strings.Contains(app.ExportMermaid(), "\tstate s1 {\n\t\tstate \"lobby\" as s2\n"))
+}
@@ -0,0 +1,218 @@
+package fsm
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// describeConditions 按照名称顺序描述条件并以 separator 连接，没有实现 fmt.Stringer 的条件使用条件名称
+func describeConditions(conditions map[string]ICondition, separator string) string {
+	var parts []string
+	for _, name := range sortedKeys(conditions) {
+		if stringer, ok := conditions[name].(fmt.Stringer); ok {
+			parts = append(parts, stringer.String())
+		} else {
+			parts = append(parts, name)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+// graphEdge 状态图中的一条边
+type graphEdge struct {
+	from  State
+	to    State
+	label string
+	// manual 只在 ValidTransition 中登记，没有自动转换
+	manual bool
+}
+
+// graphEdges 返回自动转换以及没有自动转换的 ValidTransition，自动转换的标签形如 name [conditions]，
+// 事件转换的标签形如 event [conditions]，多个条件之间是或的关系
+func (t *StateMachine) graphEdges() (edges []graphEdge) {
+	auto := make(map[[2]State]bool)
+	for _, name := range sortedKeys(t.Transitions) {
+		trans := t.Transitions[name]
+		label := name
+		if trans.Event != "" {
+			label = trans.Event
+		}
+		if len(trans.Conditions) > 0 {
+			label += " [" + describeConditions(trans.Conditions, " or ") + "]"
+		}
+		edges = append(edges, graphEdge{from: trans.From, to: trans.To, label: label})
+		auto[[2]State{trans.From, trans.To}] = true
+	}
+
+	for _, from := range t.orderedStates(sortedKeys(t.ValidTransition)) {
+		for _, to := range t.ValidTransition[from] {
+			if to != "" && !auto[[2]State{from, to}] {
+				edges = append(edges, graphEdge{from: from, to: to, manual: true})
+			}
+		}
+	}
+	return edges
+}
+
+// orderedStates 先按照 States 的顺序返回状态，再返回 extra 中不在 States 里的状态
+func (t *StateMachine) orderedStates(extra []State) (states []State) {
+	for _, state := range t.States {
+		if slices.Contains(extra, state) {
+			states = append(states, state)
+		}
+	}
+	for _, state := range extra {
+		if !slices.Contains(t.States, state) {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// ExportDOT 生成 Graphviz DOT 格式的状态图，子状态机画为 cluster，绑定的子状态机在标签中注明父状态，虚线为只能手动切换的 ValidTransition，当前状态会被填充颜色
+func (t *StateMachine) ExportDOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(t.Name))
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+	t.writeDOT(&b, t.Name, "\t")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (t *StateMachine) writeDOT(b *strings.Builder, path string, indent string) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	id := func(state State) string {
+		return strconv.Quote(path + "/" + state)
+	}
+
+	entry := `shape=point, label=""`
+	if t.CurrentState == "Entry" {
+		entry += ", color=orange"
+	}
+	fmt.Fprintf(b, "%s%s [%s];\n", indent, id("Entry"), entry)
+	for _, state := range t.States {
+		attrs := "label=" + strconv.Quote(state)
+		if state == t.CurrentState {
+			attrs += `, style="rounded,filled", fillcolor=orange`
+		}
+		fmt.Fprintf(b, "%s%s [%s];\n", indent, id(state), attrs)
+	}
+	if len(t.States) > 0 {
+		fmt.Fprintf(b, "%s%s -> %s;\n", indent, id("Entry"), id(t.States[0]))
+	}
+
+	for _, edge := range t.graphEdges() {
+		var attrs []string
+		if edge.label != "" {
+			attrs = append(attrs, "label="+strconv.Quote(edge.label))
+		}
+		if edge.manual {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(b, "%s%s -> %s", indent, id(edge.from), id(edge.to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+
+	for _, name := range sortedKeys(t.SubMachines) {
+		subPath := path + "/" + name
+		fmt.Fprintf(b, "%ssubgraph %s {\n", indent, strconv.Quote("cluster_"+subPath))
+		fmt.Fprintf(b, "%s\tlabel=%s;\n", indent, strconv.Quote(subMachineLabel(t.SubMachines[name])))
+		t.SubMachines[name].writeDOT(b, subPath, indent+"\t")
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+}
+
+// ExportMermaid 生成 Mermaid stateDiagram-v2 格式的状态图，子状态机画为复合状态，绑定的子状态机画为父状态的复合状态，当前状态使用 current 样式
+func (t *StateMachine) ExportMermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	ids := make(map[string]string)
+	var current []string
+	t.writeMermaid(&b, t.Name, "\t", ids, &current)
+	if len(current) > 0 {
+		b.WriteString("\tclassDef current fill:orange\n")
+		for _, id := range current {
+			fmt.Fprintf(&b, "\tclass %s current\n", id)
+		}
+	}
+	return b.String()
+}
+
+// writeMermaid Mermaid 的状态 id 只能包含字母、数字和下划线，所以为每个状态生成 id，状态名称作为描述
+func (t *StateMachine) writeMermaid(b *strings.Builder, path string, indent string, ids map[string]string, current *[]string) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	id := func(state State) string {
+		key := path + "/" + state
+		if _, ok := ids[key]; !ok {
+			ids[key] = "s" + strconv.Itoa(len(ids))
+		}
+		return ids[key]
+	}
+
+	for _, state := range t.States {
+		fmt.Fprintf(b, "%sstate \"%s\" as %s\n", indent, escapeMermaid(state), id(state))
+		if state == t.CurrentState {
+			*current = append(*current, id(state))
+		}
+	}
+	if len(t.States) > 0 {
+		fmt.Fprintf(b, "%s[*] --> %s\n", indent, id(t.States[0]))
+	}
+
+	for _, edge := range t.graphEdges() {
+		fmt.Fprintf(b, "%s%s --> %s", indent, id(edge.from), id(edge.to))
+		if edge.label != "" {
+			fmt.Fprintf(b, " : %s", escapeMermaid(edge.label))
+		}
+		b.WriteString("\n")
+	}
+
+	for _, name := range sortedKeys(t.SubMachines) {
+		subPath := path + "/" + name
+		sub := t.SubMachines[name]
+		var subID string
+		if sub.ParentState != "" && slices.Contains(t.States, sub.ParentState) {
+			// 绑定的子状态机画为父状态的复合状态
+			subID = id(sub.ParentState)
+		} else {
+			subID = "m" + strconv.Itoa(len(ids))
+			ids[subPath+"/"] = subID
+			fmt.Fprintf(b, "%sstate \"%s\" as %s\n", indent, escapeMermaid(name), subID)
+		}
+		fmt.Fprintf(b, "%sstate %s {\n", indent, subID)
+		t.SubMachines[name].writeMermaid(b, subPath, indent+"\t", ids, current)
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+}
+
+// subMachineLabel 绑定的子状态机标签形如 name (state, H)，H 和 H* 分别为浅历史和深历史
+func subMachineLabel(sub *StateMachine) string {
+	sub.lock.RLock()
+	defer sub.lock.RUnlock()
+
+	if sub.ParentState == "" {
+		return sub.Name
+	}
+	label := sub.Name + " (" + sub.ParentState
+	switch sub.History {
+	case HistoryTypeShallow:
+		label += ", H"
+	case HistoryTypeDeep:
+		label += ", H*"
+	}
+	return label + ")"
+}
+
+// escapeMermaid 使用 Mermaid 的实体编码替换会破坏语法的字符
+func escapeMermaid(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", ":", "#58;", ";", "#59;", "\n", " ").Replace(s)
+}
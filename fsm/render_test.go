@@ -0,0 +1,63 @@
+package fsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportGraph(t *testing.T) {
+	sm, err := LoadStateMachine([]byte(playerDefinition))
+	assert.Equal(t, nil, err)
+	sm.AutoTransit()
+	sm.GetMachine("/Player/Motion").AutoTransit()
+
+	assert.Equal(t, `digraph "Player" {
+	node [shape=box, style=rounded];
+	"Player/Entry" [shape=point, label=""];
+	"Player/idle" [label="idle", style="rounded,filled", fillcolor=orange];
+	"Player/walk" [label="walk"];
+	"Player/run" [label="run"];
+	"Player/Entry" -> "Player/idle";
+	"Player/idle" -> "Player/walk" [label="idle_walk [(speed > 0 and speed < 5)]"];
+	"Player/walk" -> "Player/run" [label="walk_run [speed > 5]"];
+	"Player/idle" -> "Player/run" [style=dashed];
+	"Player/walk" -> "Player/idle" [style=dashed];
+	"Player/run" -> "Player/walk" [style=dashed];
+	subgraph "cluster_Player/Motion" {
+		label="Motion";
+		"Player/Motion/Entry" [shape=point, label=""];
+		"Player/Motion/ground" [label="ground", style="rounded,filled", fillcolor=orange];
+		"Player/Motion/fly" [label="fly"];
+		"Player/Motion/Entry" -> "Player/Motion/ground";
+		"Player/Motion/ground" -> "Player/Motion/fly" [style=dashed];
+	}
+}
+`, sm.ExportDOT())
+
+	assert.Equal(t, `stateDiagram-v2
+	state "idle" as s0
+	state "walk" as s1
+	state "run" as s2
+	[*] --> s0
+	s0 --> s1 : idle_walk [(speed > 0 and speed < 5)]
+	s1 --> s2 : walk_run [speed > 5]
+	s0 --> s2
+	s1 --> s0
+	s2 --> s1
+	state "Motion" as m3
+	state m3 {
+		state "ground" as s4
+		state "fly" as s5
+		[*] --> s4
+		s4 --> s5
+	}
+	classDef current fill:orange
+	class s0 current
+	class s4 current
+`, sm.ExportMermaid())
+}
+
+func TestEscapeMermaid(t *testing.T) {
+	assert.Equal(t, "a#58; #quot;b#quot;#59; c", escapeMermaid("a: \"b\";\nc"))
+}
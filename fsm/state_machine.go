@@ -1,8 +1,10 @@
 package fsm
 
 import (
+	"errors"
 	"fmt"
 	"slices"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -16,6 +18,8 @@ func NewStateMachine(name string) *StateMachine {
 	t.Transitions = make(map[string]*Transition)
 	t.SubMachines = make(map[string]*StateMachine)
 	t.ValidTransition = make(map[string][]string)
+	t.enterActions = make(map[State][]Action)
+	t.exitActions = make(map[State][]Action)
 	t.CurrentState = "Entry"
 	t.Name = name
 	return t
@@ -24,6 +28,16 @@ func NewStateMachine(name string) *StateMachine {
 type StateMachine struct {
 	lock     sync.RWMutex
 	callback func(from State, to State)
+	// enterActions 进入状态时执行的动作
+	enterActions map[State][]Action
+	// exitActions 离开状态时执行的动作
+	exitActions map[State][]Action
+	// guards 对所有状态切换生效的守卫函数
+	guards []Guard
+	// parent 父状态机
+	parent *StateMachine
+	// lastState 上次停止时的状态，用于历史恢复
+	lastState State
 	// Parameters 参数列表
 	Parameters map[string]*Parameter
 	// States 所有状态列表
@@ -40,8 +54,13 @@ type StateMachine struct {
 	SubMachines map[string]*StateMachine
 	// Name 状态机的名称
 	Name string
+	// ParentState 绑定的父状态机状态，为空时生命周期不受父状态机影响
+	ParentState State
+	// History 绑定后重新启动时如何恢复状态
+	History HistoryType
 }
 
+// AddSubMachine 添加子状态机，子状态机的生命周期需要跟随父状态机的状态时再使用 BindSubMachine 绑定
 func (t *StateMachine) AddSubMachine(machine *StateMachine) (err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -53,7 +72,7 @@ func (t *StateMachine) AddSubMachine(machine *StateMachine) (err error) {
 	return
 }
 
-// SetStateUpdatedCallback 设置状态发生切换时触发的回调函数
+// SetStateUpdatedCallback 设置状态发生切换时触发的回调函数，在进入状态的动作之后执行
 func (t *StateMachine) SetStateUpdatedCallback(callback func(from State, to State)) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -141,17 +160,18 @@ func (t *StateMachine) AddAutoTransition(trans *Transition, parameter *Parameter
 
 // RemoveAutoTransition 移除自动状态转换
 func (t *StateMachine) RemoveAutoTransition(transitionName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	transition, ok := t.Transitions[transitionName]
 	if !ok {
 		return
 	}
 
 	for parameter := range t.ParametersLink {
-		for k, trans := range t.ParametersLink[parameter] {
-			if trans == transition {
-				slices.Delete(t.ParametersLink[parameter], k, k+1)
-			}
-		}
+		t.ParametersLink[parameter] = slices.DeleteFunc(t.ParametersLink[parameter], func(trans *Transition) bool {
+			return trans == transition
+		})
 		if len(t.ParametersLink[parameter]) < 1 {
 			delete(t.ParametersLink, parameter)
 		}
@@ -160,60 +180,71 @@ func (t *StateMachine) RemoveAutoTransition(transitionName string) {
 	delete(t.Transitions, transitionName)
 }
 
-// SetState 手动设置状态机状态，但会检查条件是否满足
+// SetState 手动设置状态机状态，但会检查条件是否满足，守卫函数拒绝时返回 *GuardError
 func (t *StateMachine) SetState(toState State) (err error) {
-	if t.checkTransitionValid(t.CurrentState, toState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.checkTransitionValid(t.CurrentState, toState) {
 		return fmt.Errorf("SetState fromState=%s toState=%s was not registered in valid transition set", t.CurrentState, toState)
 	}
 
-	// 查找条件约束
+	// 查找条件约束，事件转换只能由 Fire 触发
 	var transSet []*Transition
-	for _, trans := range t.Transitions {
-		if trans.From == t.CurrentState && trans.To == toState {
+	for _, trans := range t.sortedTransitions() {
+		if trans.From == t.CurrentState && trans.To == toState && trans.Event == "" {
 			transSet = append(transSet, trans)
 		}
 	}
 
 	if len(transSet) > 0 {
-		t.autoTransit(transSet)
-	} else {
-		t.CurrentState = toState
+		return t.autoTransit(transSet, nil)
 	}
-
-	return nil
+	return t.transit(&TransitionContext{Machine: t, From: t.CurrentState, To: toState, Parameters: t.Parameters})
 }
 
-// Transit 手动检查所有的状态切换是否需要进行一次状态切换
-func (t *StateMachine) AutoTransit() {
+// AutoTransit 手动检查所有的状态切换是否需要进行一次状态切换，转换按照名称顺序检查，守卫函数拒绝并且没有发生切换时返回 *GuardError
+func (t *StateMachine) AutoTransit() (err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
 	if t.CurrentState == "Entry" {
 		if len(t.States) > 0 {
-			t.CurrentState = t.States[0]
+			return t.transit(&TransitionContext{Machine: t, From: t.CurrentState, To: t.States[0], Parameters: t.Parameters})
 		}
 		return
 	}
 
-	var transitions []*Transition
+	return t.autoTransit(t.sortedTransitions(), nil)
+}
+
+// sortedTransitions 按照名称顺序返回所有转换
+func (t *StateMachine) sortedTransitions() (transitions []*Transition) {
 	for _, trans := range t.Transitions {
 		transitions = append(transitions, trans)
 	}
-
-	t.autoTransit(transitions)
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Name < transitions[j].Name
+	})
+	return transitions
 }
 
-func (t *StateMachine) autoTransit(transitions []*Transition) {
+// autoTransit 依次检查从当前状态出发的转换，执行第一个条件满足并且没有被守卫函数拒绝的转换，跳过事件转换，parameter 为触发检查的参数
+func (t *StateMachine) autoTransit(transitions []*Transition, parameter *Parameter) (err error) {
+	var errs []error
 	for _, trans := range transitions {
+		if trans.From != t.CurrentState || trans.Event != "" {
+			continue
+		}
 		if toState := trans.Transit(t.Parameters); toState != "" && toState != t.CurrentState {
-			var oldState = t.CurrentState
-			t.CurrentState = toState
-			if t.callback != nil {
-				t.callback(oldState, toState)
+			err = t.transit(&TransitionContext{Machine: t, Transition: trans, From: t.CurrentState, To: toState, Parameter: parameter, Parameters: t.Parameters})
+			if err == nil {
+				return nil
 			}
-			break
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
 // AddParameter 添加状态切换参数
@@ -241,7 +272,7 @@ func (t *StateMachine) RemoveParameter(parameterName string) {
 	}
 }
 
-// SetParameterValue 设置参数值并自动切换对应的状态
+// SetParameterValue 设置参数值并自动切换对应的状态，守卫函数拒绝并且没有发生切换时参数值依然会被修改，返回 *GuardError
 func (t *StateMachine) SetParameterValue(parameterName string, value string) (err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -254,7 +285,7 @@ func (t *StateMachine) SetParameterValue(parameterName string, value string) (er
 	parameter.Value = value
 
 	if transitions, ok := t.ParametersLink[parameter]; ok {
-		t.autoTransit(transitions)
+		return t.autoTransit(transitions, parameter)
 	}
 
 	return
@@ -116,3 +116,35 @@ func TestGetMachine(t *testing.T) {
 	s = sm.GetMachine("/Player/Motion/Fly")
 	assert.Equal(t, flySM.Name, s.Name)
 }
+
+func TestSetState(t *testing.T) {
+	sm := NewStateMachine("APP")
+	sm.AddValidTransition("idle", []State{"walk"})
+	sm.AddValidTransition("walk", []State{"idle"})
+	sm.AutoTransit()
+
+	// 登记过的切换可以手动设置
+	assert.Equal(t, nil, sm.SetState("walk"))
+	assert.Equal(t, "walk", sm.GetCurrentState())
+
+	// 没有登记的切换
+	assert.NotEqual(t, nil, sm.SetState("run"))
+	assert.Equal(t, "walk", sm.GetCurrentState())
+}
+
+func TestAutoTransitChecksFromState(t *testing.T) {
+	sm := NewStateMachine("APP")
+	sm.AddValidTransition("a", []State{"b"})
+	sm.AddValidTransition("c", []State{"b"})
+	parameter := &Parameter{Name: "go", Value: "false", Type: ParameterTypeBool}
+	sm.AddParameter(parameter)
+	sm.AddAutoTransition(&Transition{Name: "c_b", From: "c", To: "b", Conditions: map[string]ICondition{
+		"go": &Condition{CompareType: CompareTypeEqual, ParameterName: "go", Value: "true"},
+	}}, parameter)
+
+	// 不是从当前状态出发的转换不会执行
+	sm.AutoTransit()
+	assert.Equal(t, "a", sm.GetCurrentState())
+	sm.SetParameterValue("go", "true")
+	assert.Equal(t, "a", sm.GetCurrentState())
+}
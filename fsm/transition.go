@@ -5,6 +5,12 @@ type Transition struct {
 	Conditions map[string]ICondition
 	From       State
 	To         State
+	// Event 处理的事件名称，设置后只能通过 StateMachine.Fire 触发
+	Event string
+	// Guards 条件满足后依次执行的守卫函数，任意一个返回错误都会拒绝这次转换
+	Guards []Guard
+	// Actions 离开 From 之后、进入 To 之前依次执行的动作
+	Actions []Action
 }
 
 func (t *Transition) AddCondition(conditionName string, condition ICondition) {
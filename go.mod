@@ -1,14 +1,18 @@
 module github.com/hakur/util
 
-go 1.21
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.8.4
-	golang.org/x/text v0.3.7
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.17.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
@@ -0,0 +1,179 @@
+package network
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafeArchivePath archive entry would be written outside of the extract directory
+// ErrUnsafeArchivePath 压缩包中的文件会被解压到目标目录之外
+var ErrUnsafeArchivePath = errors.New("unsafe archive path")
+
+// extractTar 将 tar 流解包到 dir 中，拒绝绝对路径、包含 .. 的路径、指向目录之外的链接以及通过已解压的符号链接写入文件，
+// 只解包普通文件、目录、符号链接和硬链接，文件权限去掉 setuid、setgid 和 sticky 位
+func extractTar(r io.Reader, dir string) (err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// 链接目标按照真实路径检查，root 本身也需要解析符号链接
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		target, err := archiveTarget(root, header.Name)
+		if err != nil {
+			return err
+		}
+		if target == root {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = extractTarFile(tr, header, target); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// 相对链接按照链接所在目录和已经解压的符号链接解析，绝对链接一律拒绝
+			if !archiveLinkWithinDir(root, filepath.Dir(target), header.Linkname) {
+				return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafeArchivePath, header.Name, header.Linkname)
+			}
+			if err = prepareArchiveTarget(target); err != nil {
+				return err
+			}
+			if err = os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := archiveTarget(root, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err = prepareArchiveTarget(target); err != nil {
+				return err
+			}
+			if err = os.Link(source, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// archiveTarget 返回压缩包中的文件在 root 中的路径，路径不安全或者经过已解压的符号链接时返回错误
+func archiveTarget(root string, name string) (target string, err error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+
+	target = filepath.Join(root, name)
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+	}
+	if rel == "." {
+		return root, nil
+	}
+
+	// 父目录中不能有符号链接，否则可以借助先解压的链接把文件写到目录之外
+	parent := root
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		if fi, err := os.Lstat(parent); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s is under symlink %s", ErrUnsafeArchivePath, name, parent)
+		}
+	}
+	return target, nil
+}
+
+// archiveLinkWithinDir 检查 dir 中指向 linkname 的符号链接是否仍然在 root 中，
+// linkname 中的 .. 只能出现在开头，之后的每一级如果是已经解压的符号链接会先解析再检查，避免 a -> . 和 b -> a/.. 这样串联的链接指向目录之外
+func archiveLinkWithinDir(root string, dir string, linkname string) bool {
+	linkname = filepath.FromSlash(linkname)
+	if linkname == "" || filepath.IsAbs(linkname) {
+		return false
+	}
+
+	path, leading := dir, true
+	for _, part := range strings.Split(linkname, string(filepath.Separator)) {
+		switch {
+		case part == "" || part == ".":
+			continue
+		case part == "..":
+			// 中间的 .. 会在之后出现的符号链接上解析，无法提前确定位置
+			if !leading {
+				return false
+			}
+			path = filepath.Dir(path)
+		default:
+			leading = false
+			path = filepath.Join(path, part)
+			if real, err := filepath.EvalSymlinks(path); err == nil {
+				path = real
+			}
+		}
+		if !isWithinDir(root, path) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWithinDir(root string, path string) bool {
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// prepareArchiveTarget 创建父目录并删除已存在的同名文件，避免写入已存在的符号链接指向的位置
+func prepareArchiveTarget(target string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err = os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func extractTarFile(r io.Reader, header *tar.Header, target string) (err error) {
+	if err = prepareArchiveTarget(target); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
@@ -0,0 +1,178 @@
+package network
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrChecksumMismatch downloaded content does not match the expected checksum, the returned error is always a *ChecksumMismatchError
+// ErrChecksumMismatch 下载内容与期望的校验和不一致，实际返回的错误类型为 *ChecksumMismatchError
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMismatchError expected and actual checksum of downloaded content
+// ChecksumMismatchError 下载内容期望的校验和与实际的校验和
+type ChecksumMismatchError struct {
+	// Expected 期望的校验和，形如 sha256:hex
+	Expected string
+	// Actual 实际计算得到的校验和，形如 sha256:hex
+	Actual string
+}
+
+func (t *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: expected %s, got %s", ErrChecksumMismatch, t.Expected, t.Actual)
+}
+
+func (t *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+var (
+	checksumHashesLock sync.RWMutex
+	// checksumHashes 校验和前缀到哈希构造函数的映射
+	checksumHashes = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+		"blake2b": func() hash.Hash {
+			h, _ := blake2b.New512(nil)
+			return h
+		},
+		"blake2b-256": func() hash.Hash {
+			h, _ := blake2b.New256(nil)
+			return h
+		},
+		"crc32c": func() hash.Hash {
+			return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		},
+	}
+)
+
+// RegisterChecksumHash register or replace hash constructor for checksum prefix, such as RegisterChecksumHash("sha384", sha512.New384) makes "sha384:hex" checksum available
+// RegisterChecksumHash 注册或替换校验和前缀对应的哈希构造函数，比如 RegisterChecksumHash("sha384", sha512.New384) 之后即可使用 "sha384:hex" 形式的校验和
+func RegisterChecksumHash(name string, newHash func() hash.Hash) {
+	checksumHashesLock.Lock()
+	defer checksumHashesLock.Unlock()
+	checksumHashes[strings.ToLower(name)] = newHash
+}
+
+// NewChecksumHash create hash by checksum prefix, such as sha256
+// NewChecksumHash 根据校验和前缀创建哈希，比如 sha256
+func NewChecksumHash(name string) (h hash.Hash, err error) {
+	checksumHashesLock.RLock()
+	newHash, ok := checksumHashes[strings.ToLower(name)]
+	checksumHashesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownChecksumType, name)
+	}
+	return newHash(), nil
+}
+
+// parseChecksum 解析形如 algorithm:digest 的校验和，digest 支持 hex 和 base64 两种编码
+func parseChecksum(checksum string) (algorithm string, digest []byte, err error) {
+	algorithm, value, _ := strings.Cut(strings.TrimSpace(checksum), ":")
+	algorithm = strings.ToLower(algorithm)
+	value = strings.TrimSpace(value)
+
+	if _, err = NewChecksumHash(algorithm); err != nil {
+		return "", nil, err
+	}
+
+	if digest, err = hex.DecodeString(value); err == nil {
+		return
+	}
+	if digest, err = base64.StdEncoding.DecodeString(value); err == nil {
+		return
+	}
+	return "", nil, fmt.Errorf("invalid checksum digest %q", value)
+}
+
+// checksumHasher 在下载过程中按文件顺序计算校验和，紧接在已计算部分之后写入的数据直接计算，
+// 其他分段写入的数据在前面的数据计算完成后再从 src 中读取计算
+type checksumHasher struct {
+	lock      sync.Mutex
+	checksum  string
+	algorithm string
+	expected  []byte
+	hash      hash.Hash
+	// offset 已经计算到的文件偏移
+	offset int64
+	src    io.ReaderAt
+}
+
+func newChecksumHasher(checksum string, src io.ReaderAt) (t *checksumHasher, err error) {
+	t = &checksumHasher{checksum: checksum, src: src}
+	if t.algorithm, t.expected, err = parseChecksum(checksum); err != nil {
+		return nil, err
+	}
+	if t.hash, err = NewChecksumHash(t.algorithm); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reset 远程文件变化重新下载时丢弃已经计算的结果
+func (t *checksumHasher) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.hash.Reset()
+	t.offset = 0
+}
+
+// write 数据 p 已经写入到 off 位置，只计算紧接在已计算部分之后的数据
+func (t *checksumHasher) write(p []byte, off int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if off > t.offset || off+int64(len(p)) <= t.offset {
+		return
+	}
+	p = p[t.offset-off:]
+	t.hash.Write(p)
+	t.offset += int64(len(p))
+}
+
+// catchUp 从 src 中读取并计算 [offset, limit) 之间已经写入的数据
+func (t *checksumHasher) catchUp(limit int64) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if limit <= t.offset {
+		return nil
+	}
+	n, err := io.Copy(t.hash, io.NewSectionReader(t.src, t.offset, limit-t.offset))
+	t.offset += n
+	return err
+}
+
+// sameAs 判断 checksum 与当前计算的校验和是否为同一个算法和值，t 为空时返回 false
+func (t *checksumHasher) sameAs(checksum string) bool {
+	if t == nil {
+		return false
+	}
+	algorithm, expected, err := parseChecksum(checksum)
+	return err == nil && algorithm == t.algorithm && bytes.Equal(expected, t.expected)
+}
+
+// verify 比较计算得到的校验和与期望的校验和
+func (t *checksumHasher) verify() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sum := t.hash.Sum(nil)
+	if !bytes.Equal(sum, t.expected) {
+		return &ChecksumMismatchError{Expected: t.checksum, Actual: t.algorithm + ":" + hex.EncodeToString(sum)}
+	}
+	return nil
+}
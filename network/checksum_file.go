@@ -0,0 +1,178 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ErrChecksumNotFound checksum file does not list the requested file
+// ErrChecksumNotFound 校验和文件中没有要查找的文件
+var ErrChecksumNotFound = errors.New("checksum not found")
+
+// ChecksumFileEntry one line of checksum file such as SHA256SUMS, Checksum is in the algorithm:digest form accepted by DownloadOpts.Checksum
+// ChecksumFileEntry SHA256SUMS 等校验和文件中的一行，Checksum 为 DownloadOpts.Checksum 使用的 算法:摘要 格式
+type ChecksumFileEntry struct {
+	Filename string
+	Checksum string
+}
+
+// bsdChecksumAlgorithms BSD 格式校验和文件中的算法标签
+var bsdChecksumAlgorithms = map[string]string{
+	"MD5":         "md5",
+	"SHA1":        "sha1",
+	"SHA256":      "sha256",
+	"SHA512":      "sha512",
+	"BLAKE2b":     "blake2b",
+	"BLAKE2b-256": "blake2b-256",
+}
+
+// ParseChecksumFile parse checksum file in GNU coreutils format "digest  filename" or BSD format "SHA256 (filename) = digest".
+// algorithm is used for GNU format, empty algorithm is guessed from digest length as md5, sha1, sha256 or sha512
+// ParseChecksumFile 解析 GNU coreutils 格式 "摘要  文件名" 或者 BSD 格式 "SHA256 (文件名) = 摘要" 的校验和文件，
+// GNU 格式使用 algorithm 作为算法，algorithm 为空时按照摘要长度推断为 md5、sha1、sha256 或 sha512
+func ParseChecksumFile(r io.Reader, algorithm string) (entries []ChecksumFileEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseChecksumLine(line, algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("checksum file line %d: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parseChecksumLine(line string, algorithm string) (entry ChecksumFileEntry, err error) {
+	// BSD 格式：SHA256 (filename) = digest
+	if tag, rest, ok := strings.Cut(line, " ("); ok {
+		if name, ok := bsdChecksumAlgorithms[tag]; ok {
+			filename, digest, ok := strings.Cut(rest, ") = ")
+			if !ok {
+				return entry, fmt.Errorf("invalid BSD checksum line %q", line)
+			}
+			entry = ChecksumFileEntry{Filename: filename, Checksum: name + ":" + strings.TrimSpace(digest)}
+			_, _, err = parseChecksum(entry.Checksum)
+			return entry, err
+		}
+	}
+
+	// GNU 格式：digest  filename，二进制模式的文件名以 * 开头
+	digest, filename, ok := strings.Cut(line, " ")
+	if !ok {
+		return entry, fmt.Errorf("invalid checksum line %q", line)
+	}
+	filename = strings.TrimPrefix(strings.TrimLeft(filename, " "), "*")
+	if algorithm == "" {
+		switch len(digest) {
+		case 32:
+			algorithm = "md5"
+		case 40:
+			algorithm = "sha1"
+		case 64:
+			algorithm = "sha256"
+		case 128:
+			algorithm = "sha512"
+		default:
+			return entry, fmt.Errorf("%w: can not guess algorithm of digest %q", ErrUnknownChecksumType, digest)
+		}
+	}
+	entry = ChecksumFileEntry{Filename: filename, Checksum: strings.ToLower(algorithm) + ":" + digest}
+	_, _, err = parseChecksum(entry.Checksum)
+	return entry, err
+}
+
+// LookupChecksum return checksum of filename, entries are matched by full name first and then by base name, so "./dist/app.tar.gz" matches "app.tar.gz"
+// LookupChecksum 返回 filename 的校验和，先按照完整文件名匹配，再按照去掉目录后的文件名匹配，所以 "./dist/app.tar.gz" 可以匹配 "app.tar.gz"
+func LookupChecksum(entries []ChecksumFileEntry, filename string) (checksum string, err error) {
+	for _, entry := range entries {
+		if entry.Filename == filename {
+			return entry.Checksum, nil
+		}
+	}
+	for _, entry := range entries {
+		if path.Base(entry.Filename) == path.Base(filename) {
+			return entry.Checksum, nil
+		}
+	}
+	return "", fmt.Errorf("%w for %s", ErrChecksumNotFound, filename)
+}
+
+// ChecksumFileOpts where to fetch checksum file and its detached signature
+// ChecksumFileOpts 校验和文件及其签名文件的下载选项
+type ChecksumFileOpts struct {
+	// URL checksum file such as https://example.com/releases/SHA256SUMS
+	// URL 校验和文件地址，比如 https://example.com/releases/SHA256SUMS
+	URL string
+	// Algorithm algorithm of GNU format lines, empty means guess from digest length
+	// Algorithm GNU 格式的校验和算法，为空时按照摘要长度推断
+	Algorithm string
+	// SignatureURL detached signature of the checksum file, empty means do not verify signature
+	// SignatureURL 校验和文件的分离签名地址，为空时不校验签名
+	SignatureURL string
+	// Verifier verify checksum file with signature downloaded from SignatureURL, required when SignatureURL is set
+	// Verifier 使用 SignatureURL 下载的签名校验校验和文件，设置 SignatureURL 时必须设置
+	Verifier SignatureVerifier
+	// Header extra request headers
+	// Header 附加的请求头
+	Header http.Header
+}
+
+// ResolveChecksum download checksum file, verify its signature if configured, and return checksum of filename for DownloadOpts.Checksum
+// ResolveChecksum 下载校验和文件，设置了签名时校验签名，返回 filename 的校验和，可以直接用于 DownloadOpts.Checksum
+func (t *HttpDownloader) ResolveChecksum(ctx context.Context, opts *ChecksumFileOpts, filename string) (checksum string, err error) {
+	if opts.SignatureURL != "" && opts.Verifier == nil {
+		return "", errors.New("checksum file signature requires a verifier")
+	}
+
+	content, err := t.fetch(ctx, &DownloadOpts{FileURL: opts.URL, Header: opts.Header})
+	if err != nil {
+		return "", err
+	}
+	if opts.SignatureURL != "" {
+		signature, err := t.fetch(ctx, &DownloadOpts{FileURL: opts.SignatureURL, Header: opts.Header})
+		if err != nil {
+			return "", err
+		}
+		if err = opts.Verifier.Verify(bytes.NewReader(content), signature); err != nil {
+			return "", err
+		}
+	}
+
+	entries, err := ParseChecksumFile(bytes.NewReader(content), opts.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	return LookupChecksum(entries, filename)
+}
+
+// maxDocumentSize fetch 和 ParseMetalink 最多读取的字节数，用于校验和文件、签名、metalink 和镜像清单等小文件
+const maxDocumentSize = 8 << 20
+
+// fetch 将小文件下载到内存中，超过 maxDocumentSize 时返回错误
+func (t *HttpDownloader) fetch(ctx context.Context, opts *DownloadOpts) (content []byte, err error) {
+	rc, err := t.Open(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if content, err = io.ReadAll(io.LimitReader(rc, maxDocumentSize+1)); err != nil {
+		return nil, err
+	}
+	if len(content) > maxDocumentSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", opts.FileURL, maxDocumentSize)
+	}
+	return content, nil
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	sha256Sum := strings.Repeat("ab", 32)
+	md5Sum := strings.Repeat("cd", 16)
+	entries, err := ParseChecksumFile(strings.NewReader(strings.Join([]string{
+		"# comment",
+		sha256Sum + "  dist/app-linux-amd64.tar.gz",
+		md5Sum + " *app.exe",
+		"",
+		"SHA512 (app.zip) = " + strings.Repeat("ef", 64),
+	}, "\n")), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []ChecksumFileEntry{
+		{Filename: "dist/app-linux-amd64.tar.gz", Checksum: "sha256:" + sha256Sum},
+		{Filename: "app.exe", Checksum: "md5:" + md5Sum},
+		{Filename: "app.zip", Checksum: "sha512:" + strings.Repeat("ef", 64)},
+	}, entries)
+
+	checksum, err := LookupChecksum(entries, "app-linux-amd64.tar.gz")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "sha256:"+sha256Sum, checksum)
+	_, err = LookupChecksum(entries, "missing")
+	assert.Equal(t, true, errors.Is(err, ErrChecksumNotFound))
+
+	_, err = ParseChecksumFile(strings.NewReader("abc  file"), "")
+	assert.Equal(t, true, errors.Is(err, ErrUnknownChecksumType))
+}
+
+func TestResolveChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	sums := []byte(hex.EncodeToString(sum[:]) + "  app.tar.gz\n")
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Equal(t, nil, err)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, sums))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) { w.Write(sums) })
+	mux.HandleFunc("/SHA256SUMS.sig", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(signature)) })
+	mux.HandleFunc("/bad.sig", func(w http.ResponseWriter, r *http.Request) { w.Write(make([]byte, ed25519.SignatureSize)) })
+	mux.HandleFunc("/huge.sig", func(w http.ResponseWriter, r *http.Request) { w.Write(make([]byte, maxDocumentSize+1)) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	opts := &ChecksumFileOpts{
+		URL:          server.URL + "/SHA256SUMS",
+		SignatureURL: server.URL + "/SHA256SUMS.sig",
+		Verifier:     &Ed25519Verifier{PublicKeys: []ed25519.PublicKey{publicKey}},
+	}
+	checksum, err := dl.ResolveChecksum(context.Background(), opts, "app.tar.gz")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), checksum)
+
+	opts.SignatureURL = server.URL + "/bad.sig"
+	_, err = dl.ResolveChecksum(context.Background(), opts, "app.tar.gz")
+	assert.Equal(t, true, errors.Is(err, ErrSignatureMismatch))
+
+	// 超过 maxDocumentSize 的文档不会被完整读入内存
+	opts.SignatureURL = server.URL + "/huge.sig"
+	_, err = dl.ResolveChecksum(context.Background(), opts, "app.tar.gz")
+	assert.Equal(t, true, err != nil && strings.Contains(err.Error(), "larger than"))
+}
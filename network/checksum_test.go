@@ -0,0 +1,54 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChecksumHash(t *testing.T) {
+	for _, name := range []string{"md5", "sha1", "sha256", "sha512", "blake2b", "blake2b-256", "crc32c", "SHA256"} {
+		h, err := NewChecksumHash(name)
+		assert.Equal(t, nil, err)
+		assert.NotEqual(t, nil, h)
+	}
+
+	_, err := NewChecksumHash("sha384")
+	assert.Equal(t, true, errors.Is(err, ErrUnknownChecksumType))
+
+	RegisterChecksumHash("sha384", sha512.New384)
+	_, err = NewChecksumHash("sha384")
+	assert.Equal(t, nil, err)
+}
+
+func TestChecksumHasher(t *testing.T) {
+	content := []byte("hello world")
+	h, _ := NewChecksumHash("crc32c")
+	h.Write(content)
+	checksum := "crc32c:" + hex.EncodeToString(h.Sum(nil))
+
+	// 分段乱序写入，后面的数据通过 catchUp 补算
+	hasher, err := newChecksumHasher(checksum, bytes.NewReader(content))
+	assert.Equal(t, nil, err)
+	hasher.write(content[6:], 6)
+	hasher.write(content[:6], 0)
+	assert.Equal(t, int64(6), hasher.offset)
+	assert.Equal(t, nil, hasher.catchUp(int64(len(content))))
+	assert.Equal(t, nil, hasher.verify())
+
+	hasher, err = newChecksumHasher("crc32c:00000000", bytes.NewReader(content))
+	assert.Equal(t, nil, err)
+	hasher.write(content, 0)
+	err = hasher.verify()
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	var mismatchErr *ChecksumMismatchError
+	assert.Equal(t, true, errors.As(err, &mismatchErr))
+	assert.Equal(t, checksum, mismatchErr.Actual)
+
+	_, err = newChecksumHasher("whirlpool:00", nil)
+	assert.Equal(t, true, errors.Is(err, ErrUnknownChecksumType))
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// CredentialProvider add credentials to requests HttpDownloader sends to the host of FileURL and refresh them when server responds 401
+// CredentialProvider 为 HttpDownloader 发送到 FileURL 所在主机的请求添加认证信息，服务器返回 401 时刷新认证信息
+type CredentialProvider interface {
+	// Authorize add credentials to request before it is sent
+	// Authorize 在请求发送前添加认证信息
+	Authorize(req *http.Request) error
+	// Refresh called when server responds 401, return true to send the request again with refreshed credentials
+	// Refresh 服务器返回 401 时调用，返回 true 表示认证信息已刷新，请求会使用新的认证信息重新发送一次
+	Refresh(ctx context.Context, resp *http.Response) (retry bool, err error)
+}
+
+// CredentialFunc adapter to use ordinary function as CredentialProvider which never refreshes, such as signing query parameters
+// CredentialFunc 将普通函数适配为不会刷新的 CredentialProvider，比如为请求的查询参数签名
+type CredentialFunc func(req *http.Request) error
+
+func (t CredentialFunc) Authorize(req *http.Request) error {
+	return t(req)
+}
+
+func (t CredentialFunc) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	return false, nil
+}
+
+// BasicAuthCredential http basic authentication
+// BasicAuthCredential http basic 认证
+type BasicAuthCredential struct {
+	Username string
+	Password string
+}
+
+func (t *BasicAuthCredential) Authorize(req *http.Request) error {
+	req.SetBasicAuth(t.Username, t.Password)
+	return nil
+}
+
+func (t *BasicAuthCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	return false, nil
+}
+
+// HeaderCredential static headers such as X-Api-Key
+// HeaderCredential 固定的认证请求头，比如 X-Api-Key
+type HeaderCredential struct {
+	Header http.Header
+}
+
+func (t *HeaderCredential) Authorize(req *http.Request) error {
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	return nil
+}
+
+func (t *HeaderCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	return false, nil
+}
+
+// QueryCredential static query parameters such as pre-signed tokens
+// QueryCredential 固定的认证查询参数，比如预签名的 token
+type QueryCredential struct {
+	Values url.Values
+}
+
+func (t *QueryCredential) Authorize(req *http.Request) error {
+	query := req.URL.Query()
+	for k, v := range t.Values {
+		query[k] = v
+	}
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+func (t *QueryCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	return false, nil
+}
+
+// NewBearerTokenCredential create bearer token credential, refreshFunc is called to fetch a new token when server responds 401, nil means token never refreshes
+// NewBearerTokenCredential 新建 bearer token 认证，服务器返回 401 时调用 refreshFunc 获取新的 token，为空时不刷新
+func NewBearerTokenCredential(token string, refreshFunc func(ctx context.Context) (token string, err error)) (t *BearerTokenCredential) {
+	t = new(BearerTokenCredential)
+	t.token = token
+	t.refreshFunc = refreshFunc
+	return t
+}
+
+// BearerTokenCredential bearer token credential, safe for concurrent segments, only one refresh happens for concurrent 401 responses
+// BearerTokenCredential bearer token 认证，可以被多个分段同时使用，同时收到多个 401 时只刷新一次
+type BearerTokenCredential struct {
+	lock        sync.Mutex
+	token       string
+	refreshFunc func(ctx context.Context) (token string, err error)
+}
+
+// Token return current token
+// Token 返回当前的 token
+func (t *BearerTokenCredential) Token() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.token
+}
+
+func (t *BearerTokenCredential) Authorize(req *http.Request) error {
+	if token := t.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func (t *BearerTokenCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	if t.refreshFunc == nil {
+		return false, nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// 其他分段已经刷新过 token 了，直接使用新的 token 重试
+	if resp.Request != nil && resp.Request.Header.Get("Authorization") != "Bearer "+t.token {
+		return true, nil
+	}
+
+	token, err := t.refreshFunc(ctx)
+	if err != nil {
+		return false, err
+	}
+	t.token = token
+	return true, nil
+}
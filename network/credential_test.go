@@ -0,0 +1,119 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpDownloadCredentials(t *testing.T) {
+	var validToken atomic.Value
+	validToken.Store("token-1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		switch {
+		case r.Header.Get("Authorization") == "Bearer "+validToken.Load().(string):
+		case user == "user" && password == "password":
+		case r.Header.Get("X-Api-Key") == "key" && r.Header.Get("X-Trace") == "trace":
+		case r.URL.Query().Get("signature") == "abc" && r.URL.Query().Get("keep") == "1":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "file")
+	download := func(credentials CredentialProvider, header http.Header) error {
+		dl, err := NewHttpDownloader(&HttpDownloaderOpts{Credentials: credentials})
+		if err != nil {
+			return err
+		}
+		return dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "?keep=1", OutputFilename: output, Header: header})
+	}
+
+	var statusErr *HttpStatusError
+	assert.Equal(t, true, errors.As(download(nil, nil), &statusErr))
+	assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+
+	assert.Equal(t, nil, download(&BasicAuthCredential{Username: "user", Password: "password"}, nil))
+	assert.Equal(t, nil, download(&HeaderCredential{Header: http.Header{"X-Api-Key": {"key"}}}, http.Header{"X-Trace": {"trace"}}))
+	assert.Equal(t, nil, download(&QueryCredential{Values: url.Values{"signature": {"abc"}}}, nil))
+	assert.Equal(t, nil, download(CredentialFunc(func(req *http.Request) error {
+		req.SetBasicAuth("user", "password")
+		return nil
+	}), nil))
+
+	// token 过期后刷新并重试
+	var refreshCount int
+	credential := NewBearerTokenCredential("token-1", func(ctx context.Context) (string, error) {
+		refreshCount++
+		return "token-2", nil
+	})
+	assert.Equal(t, nil, download(credential, nil))
+	validToken.Store("token-2")
+	assert.Equal(t, nil, download(credential, nil))
+	assert.Equal(t, 1, refreshCount)
+	assert.Equal(t, "token-2", credential.Token())
+
+	refreshErr := errors.New("refresh failed")
+	validToken.Store("token-3")
+	err := download(NewBearerTokenCredential("token-2", func(ctx context.Context) (string, error) {
+		return "", refreshErr
+	}), nil)
+	assert.Equal(t, true, errors.Is(err, refreshErr))
+}
+
+func TestHttpDownloadCredentialsHost(t *testing.T) {
+	var lock sync.Mutex
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			lock.Lock()
+			leaked = append(leaked, r.URL.Path)
+			lock.Unlock()
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.Header.Get("X-Api-Key") != "key" && (user != "user" || password != "password") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, other.URL+"/redirected", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	for _, credential := range []CredentialProvider{
+		&BasicAuthCredential{Username: "user", Password: "password"},
+		&HeaderCredential{Header: http.Header{"X-Api-Key": {"key"}}},
+	} {
+		dl, err := NewHttpDownloader(&HttpDownloaderOpts{Credentials: credential})
+		assert.Equal(t, nil, err)
+
+		// 镜像不会收到 FileURL 的认证信息
+		err = dl.Download(context.Background(), &DownloadOpts{FileURL: origin.URL + "/missing", Mirrors: []string{other.URL + "/mirror"}, OutputFilename: filepath.Join(dir, "mirror")})
+		assert.Equal(t, nil, err)
+
+		// 重定向到其他主机时删除认证信息
+		err = dl.Download(context.Background(), &DownloadOpts{FileURL: origin.URL + "/redirect", OutputFilename: filepath.Join(dir, "redirect")})
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, 0, len(leaked), leaked)
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ErrUnknownCompression no decompressor registered for the compression
+// ErrUnknownCompression 没有注册对应的解压器
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// Compression compression format of downloaded file
+// Compression 下载文件的压缩格式
+type Compression = string
+
+const (
+	// CompressionNone do not decompress
+	// CompressionNone 不解压
+	CompressionNone Compression = ""
+	// CompressionAuto detect compression by file extension of FileURL, such as .gz, .tgz, .zst and .xz, unknown extensions are not decompressed
+	// CompressionAuto 根据 FileURL 的扩展名判断压缩格式，比如 .gz、.tgz、.zst 和 .xz，无法识别的扩展名不解压
+	CompressionAuto Compression = "auto"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	CompressionXz   Compression = "xz"
+)
+
+var (
+	decompressorsLock sync.RWMutex
+	// decompressors 压缩格式到解压器构造函数的映射
+	decompressors = map[string]func(r io.Reader) (io.ReadCloser, error){
+		CompressionGzip: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		CompressionZstd: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		CompressionXz: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(d), nil
+		},
+	}
+	// compressionExtensions 扩展名到压缩格式的映射，用于 CompressionAuto
+	compressionExtensions = map[string]string{
+		".gz":   CompressionGzip,
+		".tgz":  CompressionGzip,
+		".zst":  CompressionZstd,
+		".tzst": CompressionZstd,
+		".xz":   CompressionXz,
+		".txz":  CompressionXz,
+	}
+)
+
+// RegisterDecompressor register or replace decompressor for compression, extensions are used by CompressionAuto, such as RegisterDecompressor("bzip2", fn, ".bz2", ".tbz2")
+// RegisterDecompressor 注册或替换压缩格式对应的解压器，extensions 用于 CompressionAuto，比如 RegisterDecompressor("bzip2", fn, ".bz2", ".tbz2")
+func RegisterDecompressor(compression string, newReader func(r io.Reader) (io.ReadCloser, error), extensions ...string) {
+	decompressorsLock.Lock()
+	defer decompressorsLock.Unlock()
+	decompressors[compression] = newReader
+	for _, ext := range extensions {
+		compressionExtensions[strings.ToLower(ext)] = compression
+	}
+}
+
+// NewDecompressor create decompressor of compression reading from r
+// NewDecompressor 创建从 r 中读取的 compression 格式解压器
+func NewDecompressor(compression string, r io.Reader) (rc io.ReadCloser, err error) {
+	decompressorsLock.RLock()
+	newReader, ok := decompressors[compression]
+	decompressorsLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownCompression, compression)
+	}
+	return newReader(r)
+}
+
+// detectCompression CompressionAuto 时根据下载地址的扩展名判断压缩格式
+func detectCompression(compression string, fileURL string) string {
+	if compression != CompressionAuto {
+		return compression
+	}
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return CompressionNone
+	}
+	decompressorsLock.RLock()
+	defer decompressorsLock.RUnlock()
+	return compressionExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// downloadDecompressed 流式下载并解压，解压结果写入 OutputFilename 或者解包到 ExtractDir。
+// 压缩数据不落盘，所以不会保存断点续传清单，下载中断重试时从已经收到的位置继续
+func (t *HttpDownloader) downloadDecompressed(ctx context.Context, opts *DownloadOpts) (err error) {
+	compression := detectCompression(opts.Decompress, opts.FileURL)
+
+	// 校验解压后的数据时由这里计算校验和，下载过程不再校验
+	var hasher *checksumHasher
+	downloadOpts := *opts
+	if opts.Checksum != "" && opts.ChecksumDecompressed {
+		if hasher, err = newChecksumHasher(opts.Checksum, nil); err != nil {
+			return err
+		}
+		downloadOpts.Checksum = ""
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rc, err := t.Open(ctx, &downloadOpts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if compression != CompressionNone {
+		d, err := NewDecompressor(compression, rc)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		r = d
+	}
+	if hasher != nil {
+		r = io.TeeReader(r, hasher.hash)
+	}
+
+	if opts.ExtractDir != "" {
+		if err = extractTar(r, opts.ExtractDir); err != nil {
+			return err
+		}
+		// extractTar 不会读取 tar 的结束块和 GNU tar 的记录填充，它们也属于解压后的数据，需要计入校验和
+		if _, err = io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		return t.finishDecompressed(rc, hasher)
+	}
+
+	filename := opts.OutputFilename
+	if opts.Atomic {
+		filename = partFilename(opts.OutputFilename)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err == nil {
+		err = t.finishDecompressed(rc, hasher)
+	}
+	if !opts.Atomic {
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	// 原子写入时校验通过才重命名为目标文件，否则删除临时文件
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	return os.Rename(filename, opts.OutputFilename)
+}
+
+// finishDecompressed 压缩流结束后可能还有未读取的数据，读完才能拿到下载和压缩数据校验的结果，之后校验解压后的数据
+func (t *HttpDownloader) finishDecompressed(rc io.Reader, hasher *checksumHasher) (err error) {
+	if _, err = io.Copy(io.Discard, rc); err != nil {
+		return err
+	}
+	if hasher != nil {
+		return hasher.verify()
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package network
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+func sha256Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func makeTar(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		entry.header.Size = int64(len(entry.content))
+		if entry.header.Mode == 0 {
+			entry.header.Mode = 0644
+		}
+		assert.Equal(t, nil, tw.WriteHeader(&entry.header))
+		tw.Write([]byte(entry.content))
+	}
+	assert.Equal(t, nil, tw.Close())
+	return buf.Bytes()
+}
+
+func TestHttpDownloadDecompress(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	dir := t.TempDir()
+
+	// gzip，根据扩展名识别，校验压缩数据
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(content)
+	gw.Close()
+	server := newTestServer(t, nil, nil)
+	server.writeFile(t, "file.gz", gz.Bytes())
+	defer server.Close()
+	output := filepath.Join(dir, "gzip")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file.gz", OutputFilename: output, Decompress: CompressionAuto, Checksum: sha256Checksum(gz.Bytes())})
+	assert.Equal(t, nil, err)
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, buf)
+
+	// zstd，校验解压后的数据
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	assert.Equal(t, nil, err)
+	zw.Write(content)
+	zw.Close()
+	server.writeFile(t, "file.zst", zst.Bytes())
+	output = filepath.Join(dir, "zstd")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file.zst", OutputFilename: output, Decompress: CompressionZstd, Checksum: sha256Checksum(content), ChecksumDecompressed: true})
+	assert.Equal(t, nil, err)
+	buf, err = os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, buf)
+
+	// 校验失败时原子写入不会留下任何文件
+	output = filepath.Join(dir, "mismatch")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file.zst", OutputFilename: output, Decompress: CompressionZstd, Checksum: sha256Checksum(zst.Bytes()), ChecksumDecompressed: true, Atomic: true})
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, false, fileExists(output))
+	assert.Equal(t, false, fileExists(partFilename(output)))
+
+	// tar.xz 解包
+	archive := makeTar(t,
+		tarEntry{header: tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755}},
+		tarEntry{header: tar.Header{Name: "app/bin/tool", Typeflag: tar.TypeReg, Mode: 0755}, content: "tool"},
+		tarEntry{header: tar.Header{Name: "app/current", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"}},
+	)
+	var txz bytes.Buffer
+	xw, err := xz.NewWriter(&txz)
+	assert.Equal(t, nil, err)
+	xw.Write(archive)
+	xw.Close()
+	server.writeFile(t, "app.tar.xz", txz.Bytes())
+	extractDir := filepath.Join(dir, "extract")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/app.tar.xz", Decompress: CompressionAuto, ExtractDir: extractDir, Checksum: sha256Checksum(txz.Bytes())})
+	assert.Equal(t, nil, err)
+	buf, err = os.ReadFile(filepath.Join(extractDir, "app", "current"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "tool", string(buf))
+}
+
+func TestHttpDownloadExtractChecksumDecompressed(t *testing.T) {
+	// GNU tar 默认将归档填充到 10240 字节的记录大小
+	archive := makeTar(t, tarEntry{header: tar.Header{Name: "app/tool", Typeflag: tar.TypeReg}, content: "tool"})
+	archive = append(archive, make([]byte, 10240-len(archive))...)
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	gw.Write(archive)
+	gw.Close()
+	server := newTestServer(t, nil, nil)
+	server.writeFile(t, "app.tar.gz", tgz.Bytes())
+	defer server.Close()
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	extractDir := filepath.Join(t.TempDir(), "extract")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/app.tar.gz", Decompress: CompressionAuto, ExtractDir: extractDir, Checksum: sha256Checksum(archive), ChecksumDecompressed: true})
+	assert.Equal(t, nil, err)
+	buf, err := os.ReadFile(filepath.Join(extractDir, "app", "tool"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "tool", string(buf))
+}
+
+func TestExtractTarUnsafePath(t *testing.T) {
+	for name, archive := range map[string][]byte{
+		"parent":   makeTar(t, tarEntry{header: tar.Header{Name: "../evil", Typeflag: tar.TypeReg}, content: "x"}),
+		"absolute": makeTar(t, tarEntry{header: tar.Header{Name: "/tmp/evil", Typeflag: tar.TypeReg}, content: "x"}),
+		"symlink":  makeTar(t, tarEntry{header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../.."}}),
+		"hardlink": makeTar(t, tarEntry{header: tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}}),
+		"through symlink": makeTar(t,
+			tarEntry{header: tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			tarEntry{header: tar.Header{Name: "dir/file", Typeflag: tar.TypeReg}, content: "x"},
+		),
+		"chained symlink": makeTar(t,
+			tarEntry{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			tarEntry{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/.."}},
+			tarEntry{header: tar.Header{Name: "b/extract/evil", Typeflag: tar.TypeReg}, content: "x"},
+		),
+		"symlink created later": makeTar(t,
+			tarEntry{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/../evil"}},
+			tarEntry{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+		),
+		"chained parent": makeTar(t,
+			tarEntry{header: tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755}},
+			tarEntry{header: tar.Header{Name: "sub/a", Typeflag: tar.TypeSymlink, Linkname: ".."}},
+			tarEntry{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "sub/a/.."}},
+		),
+	} {
+		dir := t.TempDir()
+		err := extractTar(bytes.NewReader(archive), filepath.Join(dir, "extract"))
+		assert.Equal(t, true, errors.Is(err, ErrUnsafeArchivePath), name)
+		assert.Equal(t, false, fileExists(filepath.Join(dir, "evil")), name)
+	}
+
+	// 指向目录之内的相对链接依然可以解压
+	dir := filepath.Join(t.TempDir(), "extract")
+	err := extractTar(bytes.NewReader(makeTar(t,
+		tarEntry{header: tar.Header{Name: "lib/libz.so.1", Typeflag: tar.TypeReg, Mode: 0644}, content: "z"},
+		tarEntry{header: tar.Header{Name: "lib64", Typeflag: tar.TypeSymlink, Linkname: "lib"}},
+		tarEntry{header: tar.Header{Name: "bin/libz.so", Typeflag: tar.TypeSymlink, Linkname: "../lib64/libz.so.1"}},
+	)), dir)
+	assert.Equal(t, nil, err)
+	buf, err := os.ReadFile(filepath.Join(dir, "bin", "libz.so"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "z", string(buf))
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrDigestMismatch downloaded content does not match Repr-Digest, Content-Digest or Digest responded by server, the returned error is always a *DigestMismatchError
+// ErrDigestMismatch 下载内容与服务器返回的 Repr-Digest、Content-Digest 或 Digest 不一致，实际返回的错误类型为 *DigestMismatchError
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// DigestMismatchError expected digest responded by server and actual digest of downloaded content
+// DigestMismatchError 服务器返回的摘要与下载内容实际的摘要
+type DigestMismatchError struct {
+	// Expected 服务器返回的摘要，形如 sha256:hex
+	Expected string
+	// Actual 实际计算得到的摘要，形如 sha256:hex
+	Actual string
+}
+
+func (t *DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s: server responded %s, got %s", ErrDigestMismatch, t.Expected, t.Actual)
+}
+
+func (t *DigestMismatchError) Is(target error) bool {
+	return target == ErrDigestMismatch
+}
+
+// digestAlgorithms 摘要请求头中的算法名到校验和前缀的映射，按照强度从高到低排列
+var digestAlgorithms = []struct {
+	name     string
+	checksum string
+}{
+	{"sha-512", "sha512"},
+	{"sha-256", "sha256"},
+	{"sha", "sha1"},
+	{"md5", "md5"},
+}
+
+// parseDigestHeader 解析 RFC 9530 的 Repr-Digest、Content-Digest 或者 RFC 3230 的 Digest 请求头，返回其中最强算法的校验和，形如 sha256:hex，
+// 没有支持的算法时返回空
+func parseDigestHeader(value string) (checksum string) {
+	digests := make(map[string][]byte)
+	for _, member := range strings.Split(value, ",") {
+		name, digest, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+		// RFC 9530 的值是 :base64: 形式的字节序列，后面可能带有参数，RFC 3230 的值是不带冒号的 base64
+		digest = strings.TrimSpace(digest)
+		if strings.HasPrefix(digest, ":") {
+			digest, _, _ = strings.Cut(digest[1:], ":")
+		}
+		if sum, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			digests[strings.ToLower(strings.TrimSpace(name))] = sum
+		}
+	}
+
+	for _, algorithm := range digestAlgorithms {
+		sum, ok := digests[algorithm.name]
+		if !ok {
+			continue
+		}
+		if h, err := NewChecksumHash(algorithm.checksum); err == nil && h.Size() == len(sum) {
+			return algorithm.checksum + ":" + hex.EncodeToString(sum)
+		}
+	}
+	return ""
+}
+
+// responseDigest 返回响应头中完整文件的摘要，Repr-Digest 优先，其次是 Content-Digest 和 Digest。
+// Content-Digest 是响应体的摘要，只有 full 为 true 即响应体就是完整文件时才能使用
+func responseDigest(header http.Header, full bool) (checksum string) {
+	if checksum = parseDigestHeader(header.Get("Repr-Digest")); checksum != "" {
+		return
+	}
+	if full {
+		if checksum = parseDigestHeader(header.Get("Content-Digest")); checksum != "" {
+			return
+		}
+	}
+	return parseDigestHeader(header.Get("Digest"))
+}
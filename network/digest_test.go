@@ -0,0 +1,130 @@
+package network
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDigestHeader(t *testing.T) {
+	content := []byte("hello world")
+	sha256Sum := sha256.Sum256(content)
+	sha512Sum := sha512.Sum512(content)
+	md5Sum := md5.Sum(content)
+	b64 := base64.StdEncoding.EncodeToString
+
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha256Sum[:]), parseDigestHeader("sha-256=:"+b64(sha256Sum[:])+":"))
+	// 选择最强的算法，忽略未知算法和参数
+	assert.Equal(t, "sha512:"+hex.EncodeToString(sha512Sum[:]), parseDigestHeader("unixsum=:MTIz:, sha-256=:"+b64(sha256Sum[:])+":, sha-512=:"+b64(sha512Sum[:])+":;x=1"))
+	// RFC 3230 的 Digest
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha256Sum[:]), parseDigestHeader("MD5="+b64(md5Sum[:])+",SHA-256="+b64(sha256Sum[:])))
+	assert.Equal(t, "md5:"+hex.EncodeToString(md5Sum[:]), parseDigestHeader("MD5="+b64(md5Sum[:])))
+	// 长度不对的摘要
+	assert.Equal(t, "", parseDigestHeader("sha-256=:"+b64(md5Sum[:])+":"))
+	assert.Equal(t, "", parseDigestHeader(""))
+
+	header := http.Header{}
+	header.Set("Content-Digest", "sha-256=:"+b64(sha256Sum[:])+":")
+	assert.Equal(t, "", responseDigest(header, false))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha256Sum[:]), responseDigest(header, true))
+	header.Set("Repr-Digest", "md5=:"+b64(md5Sum[:])+":")
+	assert.Equal(t, "md5:"+hex.EncodeToString(md5Sum[:]), responseDigest(header, true))
+}
+
+func TestHttpDownloadVerification(t *testing.T) {
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	sum := sha256.Sum256(content)
+	goodDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	badSum := sha256.Sum256([]byte("other"))
+	badDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(badSum[:]) + ":"
+
+	// mode 决定服务器的行为
+	var mode string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch mode {
+		case "digest":
+			w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+			w.Write(content)
+		case "bad-digest":
+			w.Header().Set("Repr-Digest", badDigest)
+			w.Header().Set("Content-Digest", goodDigest)
+			w.Write(content)
+		case "short":
+			// 声明的长度比实际发送的多，服务器会在发送完后断开连接
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+		case "range-length":
+			if r.Header.Get("Range") == "bytes=0-0" {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[:1])
+				return
+			}
+			// Content-Range 与 Content-Length 不一致
+			var start, end int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[start:end])
+		}
+	}))
+	defer server.Close()
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	output := filepath.Join(t.TempDir(), "file")
+
+	mode = "digest"
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL, OutputFilename: output})
+	assert.Equal(t, nil, err)
+
+	// Repr-Digest 优先于 Content-Digest，原子写入时删除临时文件
+	mode = "bad-digest"
+	os.Remove(output)
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL, OutputFilename: output, Atomic: true})
+	assert.Equal(t, true, errors.Is(err, ErrDigestMismatch))
+	var digestErr *DigestMismatchError
+	assert.Equal(t, true, errors.As(err, &digestErr))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(badSum[:]), digestErr.Expected)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), digestErr.Actual)
+	assert.Equal(t, false, fileExists(output))
+	assert.Equal(t, false, fileExists(partFilename(output)))
+
+	err = dl.DownloadTo(context.Background(), &DownloadOpts{FileURL: server.URL}, io.Discard)
+	assert.Equal(t, true, errors.Is(err, ErrDigestMismatch))
+
+	mode = "short"
+	err = dl.DownloadTo(context.Background(), &DownloadOpts{FileURL: server.URL}, io.Discard)
+	assert.Equal(t, true, errors.Is(err, ErrShortRead))
+	assert.Equal(t, true, errors.Is(err, io.ErrUnexpectedEOF))
+	var shortErr *ShortReadError
+	assert.Equal(t, true, errors.As(err, &shortErr))
+	assert.Equal(t, int64(len(content)), shortErr.Expected)
+
+	mode = "range-length"
+	dl.WorkerCount = 2
+	dl.MinSegmentSize = 1024
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL, OutputFilename: filepath.Join(t.TempDir(), "file")})
+	assert.Equal(t, true, errors.Is(err, ErrLengthMismatch))
+	var lengthErr *LengthMismatchError
+	assert.Equal(t, true, errors.As(err, &lengthErr))
+	assert.Equal(t, lengthErr.Expected-1, lengthErr.Actual)
+}
@@ -0,0 +1,326 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DownloadCacheOpts options of DownloadCache
+// DownloadCacheOpts DownloadCache 的选项
+type DownloadCacheOpts struct {
+	// Dir cache directory, created if not exists
+	// Dir 缓存目录，不存在时自动创建
+	Dir string
+	// MaxSize total bytes of cached files kept by Evict, least recently used files are removed first, 0 means unlimited
+	// MaxSize Evict 之后保留的缓存文件总字节数，优先删除最久没有使用的文件，0 表示不限制
+	MaxSize int64
+	// MaxAge files not used for longer than MaxAge are removed by Evict, 0 means never expire
+	// MaxAge 超过 MaxAge 没有使用的文件会被 Evict 删除，0 表示永不过期
+	MaxAge time.Duration
+}
+
+// DownloadCache content addressed cache of downloaded files shared by HttpDownloader.Download.
+// Files are stored once by sha256 of content and indexed by URL and checksum, a cached file is revalidated by sending If-None-Match/If-Modified-Since
+// with the download request, it is copied into OutputFilename when server responds 304 and the response body is downloaded otherwise. Cache entries without ETag or Last-Modified are only kept for downloads with a checksum,
+// which are then served without contacting server. DownloadCache is safe for concurrent use in one process, but not across processes
+// DownloadCache HttpDownloader.Download 共用的下载文件缓存，文件按照内容的 sha256 只保存一份，并以下载地址和校验和作为索引。
+// 再次下载时在下载请求中带上 If-None-Match/If-Modified-Since 校验缓存，服务器返回 304 时直接从缓存复制到 OutputFilename，否则下载响应体。
+// 没有 ETag 和 Last-Modified 的文件只在设置了校验和时缓存，再次下载时不访问服务器直接使用缓存。DownloadCache 可以在一个进程中并发使用，但不能被多个进程共用
+type DownloadCache struct {
+	opts *DownloadCacheOpts
+	lock sync.Mutex
+}
+
+// cacheEntry 缓存索引，保存在 entries 目录下，文件修改时间即最后使用时间
+type cacheEntry struct {
+	URL          string `json:"url"`
+	Checksum     string `json:"checksum,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Blob 文件内容的 sha256，即 blobs 目录下的文件名
+	Blob string `json:"blob"`
+	Size int64  `json:"size"`
+}
+
+// NewDownloadCache create cache in opts.Dir
+// NewDownloadCache 在 opts.Dir 中新建缓存
+func NewDownloadCache(opts *DownloadCacheOpts) (t *DownloadCache, err error) {
+	for _, dir := range []string{filepath.Join(opts.Dir, "entries"), filepath.Join(opts.Dir, "blobs")} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	t = new(DownloadCache)
+	t.opts = opts
+	return t, nil
+}
+
+// Remove remove cache entry of fileURL and checksum, the cached file is removed by next Evict if no other entry uses it
+// Remove 删除 fileURL 和 checksum 对应的缓存索引，没有其他索引使用的缓存文件会在下次 Evict 时删除
+func (t *DownloadCache) Remove(fileURL string, checksum string) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err = os.Remove(t.entryFilename(fileURL, checksum)); err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return
+}
+
+// Clear remove all cached files
+// Clear 删除所有缓存文件
+func (t *DownloadCache) Clear() (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, dir := range []string{filepath.Join(t.opts.Dir, "entries"), filepath.Join(t.opts.Dir, "blobs")} {
+		if err = os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evict remove entries not used for longer than MaxAge, then remove least recently used entries until cached files fit in MaxSize
+// Evict 删除超过 MaxAge 没有使用的缓存，然后按照最久没有使用的顺序删除缓存，直到缓存文件总大小不超过 MaxSize
+func (t *DownloadCache) Evict() (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.evictLocked()
+}
+
+// cachedEntry 带有索引文件路径和最后使用时间的缓存索引
+type cachedEntry struct {
+	cacheEntry
+	filename string
+	usedAt   time.Time
+}
+
+func (t *DownloadCache) evictLocked() (err error) {
+	entries, err := t.entriesLocked()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var kept []*cachedEntry
+	for _, entry := range entries {
+		if t.opts.MaxAge > 0 && now.Sub(entry.usedAt) > t.opts.MaxAge {
+			os.Remove(entry.filename)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	// 同一个文件可能被多个索引使用，只计算一次大小
+	refs := make(map[string]int)
+	sizes := make(map[string]int64)
+	var totalSize int64
+	for _, entry := range kept {
+		if refs[entry.Blob] == 0 {
+			sizes[entry.Blob] = entry.Size
+			totalSize += entry.Size
+		}
+		refs[entry.Blob]++
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].usedAt.Before(kept[j].usedAt)
+	})
+	for _, entry := range kept {
+		if t.opts.MaxSize <= 0 || totalSize <= t.opts.MaxSize {
+			break
+		}
+		os.Remove(entry.filename)
+		if refs[entry.Blob]--; refs[entry.Blob] == 0 {
+			totalSize -= sizes[entry.Blob]
+		}
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(t.opts.Dir, "blobs"))
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		// 正在写入的临时文件不属于任何索引
+		if refs[blob.Name()] == 0 && !strings.HasPrefix(blob.Name(), ".tmp-") {
+			os.Remove(filepath.Join(t.opts.Dir, "blobs", blob.Name()))
+		}
+	}
+	return nil
+}
+
+// entriesLocked 读取所有缓存索引，忽略已损坏的索引文件
+func (t *DownloadCache) entriesLocked() (entries []*cachedEntry, err error) {
+	dir := filepath.Join(t.opts.Dir, "entries")
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		entry := &cachedEntry{filename: filepath.Join(dir, file.Name())}
+		fi, err := file.Info()
+		if err != nil {
+			continue
+		}
+		buf, err := os.ReadFile(entry.filename)
+		if err != nil || json.Unmarshal(buf, &entry.cacheEntry) != nil {
+			os.Remove(entry.filename)
+			continue
+		}
+		entry.usedAt = fi.ModTime()
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// entryFilename 缓存索引以下载地址和校验和的 sha256 命名
+func (t *DownloadCache) entryFilename(fileURL string, checksum string) string {
+	sum := sha256.Sum256([]byte(fileURL + "\x00" + checksum))
+	return filepath.Join(t.opts.Dir, "entries", hex.EncodeToString(sum[:])+".json")
+}
+
+func (t *DownloadCache) blobFilename(blob string) string {
+	return filepath.Join(t.opts.Dir, "blobs", blob)
+}
+
+// lookup 返回下载地址和校验和对应的缓存索引，缓存文件已经不存在时返回 nil
+func (t *DownloadCache) lookup(fileURL string, checksum string) (entry *cacheEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	buf, err := os.ReadFile(t.entryFilename(fileURL, checksum))
+	if err != nil {
+		return nil
+	}
+	entry = new(cacheEntry)
+	if err = json.Unmarshal(buf, entry); err != nil || entry.URL != fileURL || entry.Checksum != checksum {
+		return nil
+	}
+	if fi, err := os.Stat(t.blobFilename(entry.Blob)); err != nil || fi.Size() != entry.Size {
+		return nil
+	}
+	return entry
+}
+
+// touch 更新缓存索引的最后使用时间
+func (t *DownloadCache) touch(entry *cacheEntry) {
+	now := time.Now()
+	os.Chtimes(t.entryFilename(entry.URL, entry.Checksum), now, now)
+}
+
+// store 将下载完成的文件复制到缓存中，既没有服务器校验信息也没有校验和的文件无法判断缓存是否有效，不会缓存
+func (t *DownloadCache) store(opts *DownloadOpts, validators *remoteValidators) (err error) {
+	entry := &cacheEntry{URL: opts.FileURL, Checksum: opts.Checksum}
+	if validators != nil {
+		entry.ETag, entry.LastModified = validators.ETag, validators.LastModified
+	}
+	if entry.ETag == "" && entry.LastModified == "" && entry.Checksum == "" {
+		return nil
+	}
+
+	src, err := os.Open(opts.OutputFilename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(t.opts.Dir, "blobs"), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if entry.Size, err = io.Copy(io.MultiWriter(tmp, hash), src); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	entry.Blob = hex.EncodeToString(hash.Sum(nil))
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err = os.Rename(tmp.Name(), t.blobFilename(entry.Blob)); err != nil {
+		return err
+	}
+	entryFilename := t.entryFilename(entry.URL, entry.Checksum)
+	if err = os.WriteFile(entryFilename+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(entryFilename+".tmp", entryFilename); err != nil {
+		return err
+	}
+	return t.evictLocked()
+}
+
+// errNotModified 带有缓存校验信息的下载请求收到了 304，缓存仍然有效
+var errNotModified = errors.New("not modified")
+
+// conditional 缓存是否需要向服务器校验，只有校验和的缓存内容已经由校验和保证
+func (t *cacheEntry) conditional() bool {
+	return t.ETag != "" || t.LastModified != ""
+}
+
+// setConditionalHeaders 为下载请求添加 If-None-Match/If-Modified-Since，服务器返回 304 时使用缓存，返回 200 时直接下载响应体
+func (t *cacheEntry) setConditionalHeaders(req *http.Request) {
+	if t.ETag != "" {
+		req.Header.Set("If-None-Match", t.ETag)
+	}
+	if t.LastModified != "" {
+		req.Header.Set("If-Modified-Since", t.LastModified)
+	}
+}
+
+// copyFromCache 将缓存文件复制到 task.file，复制时校验文件内容，缓存文件损坏时删除索引并返回 false
+func (t *HttpDownloader) copyFromCache(task *downloadTask, entry *cacheEntry) (ok bool, err error) {
+	cache := t.Opts.Cache
+	src, err := os.Open(cache.blobFilename(entry.Blob))
+	if err != nil {
+		return false, nil
+	}
+	defer src.Close()
+
+	if err = task.file.Truncate(0); err != nil {
+		return false, err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(task.file, 0), hash), src)
+	if err != nil {
+		return false, err
+	}
+	if written != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.Blob {
+		cache.Remove(entry.URL, entry.Checksum)
+		return false, nil
+	}
+	cache.touch(entry)
+
+	task.manifest = &downloadManifest{
+		URL:        task.opts.FileURL,
+		Validators: map[string]*remoteValidators{task.opts.FileURL: {ETag: entry.ETag, LastModified: entry.LastModified}},
+		TotalSize:  written,
+		Segments:   []*segment{{Start: 0, End: written - 1, Written: written}},
+	}
+	task.progress = newProgressTracker(task)
+	t.reportTotalSize(task)
+	task.progress.report(true)
+	return true, nil
+}
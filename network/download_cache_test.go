@@ -0,0 +1,85 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCache(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	server := newTestServer(t, content, nil)
+	defer server.Close()
+
+	cache, err := NewDownloadCache(&DownloadCacheOpts{Dir: filepath.Join(t.TempDir(), "cache"), MaxSize: int64(len(content)) * 3 / 2})
+	assert.Equal(t, nil, err)
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{Cache: cache})
+	assert.Equal(t, nil, err)
+
+	dir := t.TempDir()
+	download := func(name string) []byte {
+		output := filepath.Join(dir, name)
+		err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+		assert.Equal(t, nil, err)
+		buf, err := os.ReadFile(output)
+		assert.Equal(t, nil, err)
+		return buf
+	}
+	assert.Equal(t, content, download("first"))
+
+	// 文件没有变化时服务器返回 304，使用缓存中的内容，分段下载时也只发送一个请求
+	server.lock.Lock()
+	server.methods = nil
+	server.lock.Unlock()
+	dl.WorkerCount = 4
+	dl.MinSegmentSize = 1024
+	assert.Equal(t, content, download("second"))
+	server.lock.Lock()
+	assert.Equal(t, []string{http.MethodGet}, server.methods)
+	server.lock.Unlock()
+
+	// ETag 变化后重新下载，缓存超过 MaxSize 时删除旧文件，校验缓存和下载是同一个 GET 请求
+	changed := bytes.Repeat([]byte("x"), len(content))
+	server.writeFile(t, "file", changed)
+	server.lock.Lock()
+	server.methods = nil
+	server.lock.Unlock()
+	assert.Equal(t, changed, download("third"))
+	server.lock.Lock()
+	assert.Equal(t, []string{http.MethodGet}, server.methods)
+	server.lock.Unlock()
+	blobs, err := os.ReadDir(filepath.Join(cache.opts.Dir, "blobs"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(blobs))
+
+	// 只有校验和的缓存不需要访问服务器
+	sum := sha256.Sum256(changed)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	err = cache.store(&DownloadOpts{FileURL: "http://127.0.0.1:1/file", OutputFilename: filepath.Join(dir, "third"), Checksum: checksum}, nil)
+	assert.Equal(t, nil, err)
+	output := filepath.Join(dir, "offline")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: "http://127.0.0.1:1/file", OutputFilename: output, Checksum: checksum, Atomic: true})
+	assert.Equal(t, nil, err)
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, changed, buf)
+
+	// 超过 MaxAge 没有使用的缓存被删除
+	cache.opts.MaxAge = time.Hour
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(cache.entryFilename("http://127.0.0.1:1/file", checksum), old, old)
+	assert.Equal(t, nil, cache.Evict())
+	assert.Equal(t, (*cacheEntry)(nil), cache.lookup("http://127.0.0.1:1/file", checksum))
+	assert.NotEqual(t, (*cacheEntry)(nil), cache.lookup(server.URL+"/file", ""))
+
+	assert.Equal(t, nil, cache.Clear())
+	assert.Equal(t, (*cacheEntry)(nil), cache.lookup(server.URL+"/file", ""))
+}
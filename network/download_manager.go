@@ -0,0 +1,408 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// ErrJobNotFound download job id does not exist
+// ErrJobNotFound 下载任务不存在
+var ErrJobNotFound = errors.New("download job not found")
+
+// ErrJobFinished download job already finished and can not be paused, resumed or canceled
+// ErrJobFinished 下载任务已经结束，不能再暂停、恢复或取消
+var ErrJobFinished = errors.New("download job already finished")
+
+// ErrDownloadManagerClosed download manager was closed and does not accept new jobs
+// ErrDownloadManagerClosed 下载管理器已经关闭，不再接受新任务
+var ErrDownloadManagerClosed = errors.New("download manager closed")
+
+// DownloadJobState state of a download job
+// DownloadJobState 下载任务的状态
+type DownloadJobState = string
+
+const (
+	DownloadJobStateQueued    DownloadJobState = "queued"
+	DownloadJobStateRunning   DownloadJobState = "running"
+	DownloadJobStatePaused    DownloadJobState = "paused"
+	DownloadJobStateCompleted DownloadJobState = "completed"
+	DownloadJobStateFailed    DownloadJobState = "failed"
+	DownloadJobStateCanceled  DownloadJobState = "canceled"
+)
+
+// DownloadManagerOpts options of DownloadManager
+// DownloadManagerOpts 下载管理器选项
+type DownloadManagerOpts struct {
+	// MaxConcurrent max running jobs, default is 4
+	// MaxConcurrent 同时运行的最大任务数，默认 4
+	MaxConcurrent int
+	// MaxPerHost max running jobs of the same host, values <= 0 mean no limit
+	// MaxPerHost 同一个主机同时运行的最大任务数，小于等于0表示不限制
+	MaxPerHost int
+	// OnJobFinished called once in its own goroutine when a job completed, failed or canceled
+	// OnJobFinished 任务完成、失败或者取消时在单独的协程中调用一次
+	OnJobFinished func(status DownloadJobStatus)
+}
+
+// DownloadJobStatus snapshot of a download job
+// DownloadJobStatus 下载任务状态快照
+type DownloadJobStatus struct {
+	ID       string
+	Priority int
+	State    DownloadJobState
+	Progress Progress
+	// Err 任务失败或取消的原因
+	Err error
+}
+
+// DownloadManagerProgress aggregated progress of all jobs
+// DownloadManagerProgress 所有任务的汇总进度
+type DownloadManagerProgress struct {
+	// Jobs 每个状态的任务数
+	Jobs map[DownloadJobState]int
+	// TotalSize 已知大小的任务的总大小
+	TotalSize int64
+	// BytesDone 所有任务已经下载的字节数
+	BytesDone int64
+	// Speed 运行中任务的速度之和，字节每秒
+	Speed float64
+}
+
+// downloadJob 下载任务
+type downloadJob struct {
+	status DownloadJobStatus
+	opts   *DownloadOpts
+	host   string
+	// seq 相同优先级的任务按照加入顺序执行
+	seq    uint64
+	cancel context.CancelFunc
+	// stopState 运行中的任务被暂停或取消时要进入的状态
+	stopState DownloadJobState
+}
+
+func (t *downloadJob) finished() bool {
+	switch t.status.State {
+	case DownloadJobStateCompleted, DownloadJobStateFailed, DownloadJobStateCanceled:
+		return true
+	}
+	return false
+}
+
+// NewDownloadManager create download manager running jobs through downloader
+// NewDownloadManager 新建下载管理器，所有任务通过 downloader 下载
+func NewDownloadManager(downloader *HttpDownloader, opts *DownloadManagerOpts) (t *DownloadManager) {
+	t = new(DownloadManager)
+	t.downloader = downloader
+	t.opts = opts
+	t.jobs = make(map[string]*downloadJob)
+	t.hostRunning = make(map[string]int)
+	t.changed = make(chan struct{})
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t
+}
+
+// DownloadManager run download jobs with concurrency limits and priorities, jobs can be paused, resumed and canceled by id.
+// Paused jobs continue from the resume manifest written by HttpDownloader
+// DownloadManager 按照并发限制和优先级运行下载任务，可以通过任务id暂停、恢复和取消任务，暂停的任务恢复后从 HttpDownloader 的断点续传清单继续下载
+type DownloadManager struct {
+	lock       sync.Mutex
+	downloader *HttpDownloader
+	opts       *DownloadManagerOpts
+	jobs       map[string]*downloadJob
+	// order 任务id按加入顺序排列
+	order []string
+	seq   uint64
+	// running 运行中的任务数
+	running     int
+	hostRunning map[string]int
+	// changed 任务状态发生变化时关闭并替换，用于等待任务结束
+	changed chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Add add a download job, higher priority jobs run first, return job id
+// Add 添加下载任务，优先级高的任务先运行，返回任务id
+func (t *DownloadManager) Add(opts *DownloadOpts, priority int) (id string, err error) {
+	u, err := url.Parse(opts.FileURL)
+	if err != nil {
+		return "", err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.ctx.Err() != nil {
+		return "", ErrDownloadManagerClosed
+	}
+
+	t.seq++
+	id = strconv.FormatUint(t.seq, 10)
+	job := &downloadJob{
+		status: DownloadJobStatus{ID: id, Priority: priority, State: DownloadJobStateQueued, Progress: Progress{URL: opts.FileURL, OutputFilename: opts.OutputFilename, TotalSize: -1, ETA: -1}},
+		opts:   opts,
+		host:   u.Host,
+		seq:    t.seq,
+	}
+	t.jobs[id] = job
+	t.order = append(t.order, id)
+	t.scheduleLocked()
+	t.notifyLocked()
+	return id, nil
+}
+
+// Pause pause a queued or running job, running job is stopped and its partial file is kept for Resume
+// Pause 暂停排队中或运行中的任务，运行中的任务会被停止，已下载的部分保留给 Resume 继续
+func (t *DownloadManager) Pause(id string) (err error) {
+	return t.stop(id, DownloadJobStatePaused)
+}
+
+// Cancel cancel a job permanently, partial file is kept on disk
+// Cancel 永久取消任务，已下载的部分文件保留在磁盘上
+func (t *DownloadManager) Cancel(id string) (err error) {
+	return t.stop(id, DownloadJobStateCanceled)
+}
+
+// Resume put a paused job back into queue
+// Resume 将暂停的任务重新放回队列
+func (t *DownloadManager) Resume(id string) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if job.finished() {
+		return fmt.Errorf("%w: %s", ErrJobFinished, id)
+	}
+
+	switch job.status.State {
+	case DownloadJobStatePaused:
+		job.status.State = DownloadJobStateQueued
+		t.scheduleLocked()
+		t.notifyLocked()
+	case DownloadJobStateRunning:
+		// 暂停还没有生效，取消暂停即可
+		if job.stopState == DownloadJobStatePaused {
+			job.stopState = DownloadJobStateQueued
+		}
+	}
+	return nil
+}
+
+func (t *DownloadManager) stop(id string, state DownloadJobState) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if job.finished() {
+		return fmt.Errorf("%w: %s", ErrJobFinished, id)
+	}
+
+	switch job.status.State {
+	case DownloadJobStateRunning:
+		// 任务协程退出后才真正进入目标状态
+		job.stopState = state
+		job.cancel()
+	case DownloadJobStateQueued, DownloadJobStatePaused:
+		if state == DownloadJobStateCanceled {
+			job.status.Err = context.Canceled
+			t.finishLocked(job, state)
+		} else {
+			job.status.State = state
+		}
+		t.notifyLocked()
+	}
+	return nil
+}
+
+// Status return snapshot of a job
+// Status 返回任务状态快照
+func (t *DownloadManager) Status(id string) (status DownloadJobStatus, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return status, false
+	}
+	return job.status, true
+}
+
+// Jobs return snapshots of all jobs in the order they were added
+// Jobs 按加入顺序返回所有任务的状态快照
+func (t *DownloadManager) Jobs() (statuses []DownloadJobStatus) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, id := range t.order {
+		statuses = append(statuses, t.jobs[id].status)
+	}
+	return
+}
+
+// Progress return aggregated progress of all jobs
+// Progress 返回所有任务的汇总进度
+func (t *DownloadManager) Progress() (progress DownloadManagerProgress) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	progress.Jobs = make(map[DownloadJobState]int)
+	for _, job := range t.jobs {
+		progress.Jobs[job.status.State]++
+		if job.status.Progress.TotalSize > 0 {
+			progress.TotalSize += job.status.Progress.TotalSize
+		}
+		progress.BytesDone += job.status.Progress.BytesDone
+		if job.status.State == DownloadJobStateRunning {
+			progress.Speed += job.status.Progress.Speed
+		}
+	}
+	return
+}
+
+// Wait block until every job is completed, failed, canceled or paused, then return snapshots of all jobs
+// Wait 阻塞直到所有任务都已完成、失败、取消或者暂停，然后返回所有任务的状态快照
+func (t *DownloadManager) Wait(ctx context.Context) (statuses []DownloadJobStatus, err error) {
+	for {
+		t.lock.Lock()
+		var pending bool
+		for _, job := range t.jobs {
+			if job.status.State == DownloadJobStateQueued || job.status.State == DownloadJobStateRunning {
+				pending = true
+				break
+			}
+		}
+		changed := t.changed
+		t.lock.Unlock()
+
+		if !pending {
+			return t.Jobs(), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return t.Jobs(), ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Close cancel all jobs and refuse new jobs
+// Close 取消所有任务并且不再接受新任务
+func (t *DownloadManager) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.cancel()
+	for _, job := range t.jobs {
+		if job.finished() {
+			continue
+		}
+		if job.status.State == DownloadJobStateRunning {
+			job.stopState = DownloadJobStateCanceled
+			job.cancel()
+		} else {
+			job.status.Err = context.Canceled
+			t.finishLocked(job, DownloadJobStateCanceled)
+		}
+	}
+	t.notifyLocked()
+}
+
+func (t *DownloadManager) maxConcurrent() int {
+	if t.opts != nil && t.opts.MaxConcurrent > 0 {
+		return t.opts.MaxConcurrent
+	}
+	return 4
+}
+
+// scheduleLocked 在并发限制内按照优先级启动排队中的任务
+func (t *DownloadManager) scheduleLocked() {
+	for t.running < t.maxConcurrent() {
+		var next *downloadJob
+		for _, job := range t.jobs {
+			if job.status.State != DownloadJobStateQueued {
+				continue
+			}
+			if t.opts != nil && t.opts.MaxPerHost > 0 && t.hostRunning[job.host] >= t.opts.MaxPerHost {
+				continue
+			}
+			if next == nil || job.status.Priority > next.status.Priority ||
+				(job.status.Priority == next.status.Priority && job.seq < next.seq) {
+				next = job
+			}
+		}
+		if next == nil {
+			return
+		}
+		t.startLocked(next)
+	}
+}
+
+func (t *DownloadManager) startLocked(job *downloadJob) {
+	var ctx context.Context
+	ctx, job.cancel = context.WithCancel(t.ctx)
+	job.status.State = DownloadJobStateRunning
+	job.status.Err = nil
+	job.stopState = ""
+	t.running++
+	t.hostRunning[job.host]++
+
+	// 复制一份选项，在用户的进度接收者之前记录任务进度
+	opts := *job.opts
+	userReporter := job.opts.ProgressReporter
+	opts.ProgressReporter = ProgressReporterFunc(func(progress Progress) {
+		t.lock.Lock()
+		job.status.Progress = progress
+		t.lock.Unlock()
+		if userReporter != nil {
+			userReporter.ReportProgress(progress)
+		}
+	})
+
+	go func() {
+		err := t.downloader.Download(ctx, &opts)
+		job.cancel()
+
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.running--
+		t.hostRunning[job.host]--
+
+		switch {
+		case err == nil:
+			t.finishLocked(job, DownloadJobStateCompleted)
+		case job.stopState == DownloadJobStatePaused:
+			job.status.State = DownloadJobStatePaused
+		case job.stopState == DownloadJobStateQueued:
+			job.status.State = DownloadJobStateQueued
+		case job.stopState == DownloadJobStateCanceled:
+			job.status.Err = err
+			t.finishLocked(job, DownloadJobStateCanceled)
+		default:
+			job.status.Err = err
+			t.finishLocked(job, DownloadJobStateFailed)
+		}
+		t.scheduleLocked()
+		t.notifyLocked()
+	}()
+}
+
+// finishLocked 任务进入结束状态并调用 OnJobFinished
+func (t *DownloadManager) finishLocked(job *downloadJob, state DownloadJobState) {
+	job.status.State = state
+	if t.opts != nil && t.opts.OnJobFinished != nil {
+		status := job.status
+		go t.opts.OnJobFinished(status)
+	}
+}
+
+// notifyLocked 唤醒所有等待任务状态变化的协程
+func (t *DownloadManager) notifyLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
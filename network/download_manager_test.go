@@ -0,0 +1,90 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadManager(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 8*1024)
+	server := newTestServer(t, content, nil)
+	defer server.Close()
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{
+		RateLimiter:      NewRateLimiter(1024*1024, 16*1024),
+		ProgressInterval: 10 * time.Millisecond,
+	})
+	assert.Equal(t, nil, err)
+
+	var lock sync.Mutex
+	var finished []string
+	manager := NewDownloadManager(dl, &DownloadManagerOpts{MaxConcurrent: 1, OnJobFinished: func(status DownloadJobStatus) {
+		lock.Lock()
+		defer lock.Unlock()
+		finished = append(finished, status.ID)
+	}})
+	defer manager.Close()
+
+	dir := t.TempDir()
+	first, err := manager.Add(&DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(dir, "first")}, 0)
+	assert.Equal(t, nil, err)
+	low, _ := manager.Add(&DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(dir, "low")}, 0)
+	high, _ := manager.Add(&DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(dir, "high")}, 10)
+	canceled, _ := manager.Add(&DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(dir, "canceled")}, 0)
+	assert.Equal(t, nil, manager.Cancel(canceled))
+
+	// 暂停运行中的任务后恢复，从已下载的位置继续
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, nil, manager.Pause(first))
+	for {
+		if status, _ := manager.Status(first); status.State == DownloadJobStatePaused {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, nil, manager.Resume(first))
+
+	statuses, err := manager.Wait(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(statuses))
+	assert.Equal(t, DownloadJobStateCompleted, statuses[0].State)
+	assert.Equal(t, DownloadJobStateCanceled, statuses[3].State)
+	assert.Equal(t, true, errors.Is(statuses[3].Err, context.Canceled))
+	assert.Equal(t, int64(len(content)), statuses[0].Progress.BytesDone)
+
+	progress := manager.Progress()
+	assert.Equal(t, 3, progress.Jobs[DownloadJobStateCompleted])
+	assert.Equal(t, int64(len(content))*3, progress.BytesDone)
+
+	for _, name := range []string{"first", "low", "high"} {
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, bytes.Equal(content, buf))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 4, len(finished))
+	highIndex, lowIndex := -1, -1
+	for k, id := range finished {
+		switch id {
+		case high:
+			highIndex = k
+		case low:
+			lowIndex = k
+		}
+	}
+	assert.Less(t, highIndex, lowIndex)
+
+	assert.Equal(t, true, errors.Is(manager.Pause("not exists"), ErrJobNotFound))
+	assert.Equal(t, true, errors.Is(manager.Cancel(low), ErrJobFinished))
+}
@@ -0,0 +1,240 @@
+package network
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// manifestSuffix 断点续传清单文件的后缀，清单文件保存在下载目标文件旁边
+const manifestSuffix = ".dlmeta"
+
+// manifestFilename 返回下载目标文件对应的断点续传清单文件路径
+func manifestFilename(outputFilename string) string {
+	return outputFilename + manifestSuffix
+}
+
+// removeManifest 删除断点续传清单，清单不存在时不返回错误
+func removeManifest(filename string) (err error) {
+	if err = os.Remove(filename); err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return
+}
+
+// downloadManifest 断点续传清单，记录远程文件的校验信息以及每个分段已经写入的字节数
+type downloadManifest struct {
+	lock sync.Mutex
+	// URL 下载地址，地址变化时清单作废
+	URL string `json:"url"`
+	// Validators 每个下载源返回的校验信息，不同镜像的 ETag 不一定相同，所以按照下载地址分别记录
+	Validators map[string]*remoteValidators `json:"validators,omitempty"`
+	// TotalSize 文件总大小，-1 表示未知
+	TotalSize int64 `json:"totalSize"`
+	// Segments 分段列表，每个分段从 Start 开始已经连续写入了 Written 个字节
+	Segments []*segment `json:"segments"`
+}
+
+// loadDownloadManifest 读取断点续传清单，清单不存在、已损坏、下载地址不一致或者与已下载的文件对不上时返回 nil
+func loadDownloadManifest(filename string, fileURL string, fileSize int64) (m *downloadManifest) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+
+	m = new(downloadManifest)
+	if err = json.Unmarshal(buf, m); err != nil {
+		return nil
+	}
+
+	if m.URL != fileURL || len(m.Segments) < 1 {
+		return nil
+	}
+
+	for _, seg := range m.Segments {
+		if seg.Written < 0 || seg.Start+seg.Written > fileSize {
+			return nil
+		}
+		if m.TotalSize >= 0 && seg.Written > seg.size() {
+			return nil
+		}
+	}
+
+	return m
+}
+
+// save 先写入临时文件再重命名，保证进程崩溃时清单文件不会只写了一半
+func (t *downloadManifest) save(filename string) (err error) {
+	t.lock.Lock()
+	buf, err := json.Marshal(t)
+	t.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := filename + ".tmp"
+	if err = os.WriteFile(tmpFilename, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// remoteValidators 远程文件的校验信息
+type remoteValidators struct {
+	// ETag 远程文件的 ETag，用于 If-Range 校验
+	ETag string `json:"etag,omitempty"`
+	// LastModified 远程文件的 Last-Modified，没有强 ETag 时用于 If-Range 校验
+	LastModified string `json:"lastModified,omitempty"`
+	// Digest 服务器通过 Repr-Digest、Content-Digest 或 Digest 返回的完整文件摘要，形如 sha256:hex
+	Digest string `json:"digest,omitempty"`
+}
+
+// setValidators 从下载源 source 的响应头中记录远程文件的校验信息，digest 为响应头中完整文件的摘要
+func (t *downloadManifest) setValidators(source string, header http.Header, digest string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.Validators == nil {
+		t.Validators = make(map[string]*remoteValidators)
+	}
+	t.Validators[source] = &remoteValidators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Digest: digest}
+}
+
+// ifRange 返回向下载源 source 续传时 If-Range 请求头的值，弱 ETag 不能用于 If-Range，此时退回使用 Last-Modified。
+// 没有记录过校验信息的下载源返回空，此时只能依靠 Content-Range 中的文件大小和最终的校验和发现文件不一致
+func (t *downloadManifest) ifRange(source string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	v := t.Validators[source]
+	if v == nil {
+		return ""
+	}
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// validators 返回下载源 source 的校验信息
+func (t *downloadManifest) validators(source string) *remoteValidators {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.Validators[source]
+}
+
+// lastModified 按照 sources 的顺序返回第一个记录了 Last-Modified 的下载源的值
+func (t *downloadManifest) lastModified(sources []string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, source := range sources {
+		if v := t.Validators[source]; v != nil && v.LastModified != "" {
+			return v.LastModified
+		}
+	}
+	return ""
+}
+
+// digest 按照 sources 的顺序返回第一个记录了摘要的下载源的摘要
+func (t *downloadManifest) digest(sources []string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, source := range sources {
+		if v := t.Validators[source]; v != nil && v.Digest != "" {
+			return v.Digest
+		}
+	}
+	return ""
+}
+
+// remaining 返回分段还未写入部分的起始偏移，以及分段是否已经写完
+func (t *downloadManifest) remaining(seg *segment) (offset int64, done bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	offset = seg.Start + seg.Written
+	return offset, t.TotalSize >= 0 && seg.Written >= seg.size()
+}
+
+// completed 检查所有分段是否都已经写完
+func (t *downloadManifest) completed() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.TotalSize < 0 {
+		return false
+	}
+	for _, seg := range t.Segments {
+		if seg.Written < seg.size() {
+			return false
+		}
+	}
+	return true
+}
+
+// contiguousSize 返回从文件开头起连续写入的字节数
+func (t *downloadManifest) contiguousSize() (size int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, seg := range t.Segments {
+		size = seg.Start + seg.Written
+		if t.TotalSize < 0 || seg.Written < seg.size() {
+			break
+		}
+	}
+	return
+}
+
+// progress 返回已经写入的总字节数以及每个分段的进度
+func (t *downloadManifest) progress() (bytesDone int64, segments []SegmentProgress) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, seg := range t.Segments {
+		bytesDone += seg.Written
+		state := seg.state
+		if state == "" {
+			state = SegmentStatePending
+			if t.TotalSize >= 0 && seg.Written >= seg.size() {
+				state = SegmentStateDone
+			}
+		}
+		segments = append(segments, SegmentProgress{Start: seg.Start, End: seg.End, Written: seg.Written, State: state})
+	}
+	return
+}
+
+func (t *downloadManifest) totalSize() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.TotalSize
+}
+
+func (t *downloadManifest) setState(seg *segment, state SegmentState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	seg.state = state
+}
+
+// segmentWriter 写入数据的同时累加分段已经写入的字节数，并将数据交给校验和计算
+type segmentWriter struct {
+	w        io.Writer
+	manifest *downloadManifest
+	seg      *segment
+	hasher   *checksumHasher
+	digest   *checksumHasher
+}
+
+func (t *segmentWriter) Write(p []byte) (n int, err error) {
+	n, err = t.w.Write(p)
+	t.manifest.lock.Lock()
+	offset := t.seg.Start + t.seg.Written
+	t.seg.Written += int64(n)
+	t.manifest.lock.Unlock()
+
+	if t.hasher != nil {
+		t.hasher.write(p[:n], offset)
+	}
+	if t.digest != nil {
+		t.digest.write(p[:n], offset)
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"context"
+	"io"
+)
+
+// DownloadTo download opts.FileURL into w without touching disk, OutputFilename is ignored.
+// Retry, credentials, rate limit and progress work the same as Download, interrupted transfer continues from the last byte written into w.
+// Checksum is verified after the last byte was written, so when ErrChecksumMismatch is returned w already received the corrupt content
+// DownloadTo 将 opts.FileURL 下载到 w 中，不会写磁盘，忽略 OutputFilename。
+// 重试、认证、限速和进度与 Download 相同，传输中断后从最后写入 w 的字节继续。
+// 校验和在最后一个字节写入后才校验，返回 ErrChecksumMismatch 时 w 已经收到了损坏的内容
+func (t *HttpDownloader) DownloadTo(ctx context.Context, opts *DownloadOpts, w io.Writer) (err error) {
+	task := &downloadTask{opts: opts, sources: opts.sources(), writer: w}
+	task.progress = newProgressTracker(task)
+	if opts.Checksum != "" {
+		if task.hasher, err = newChecksumHasher(opts.Checksum, nil); err != nil {
+			return err
+		}
+	}
+	return t.downloadFile(ctx, task)
+}
+
+// Open return a reader of opts.FileURL backed by DownloadTo, errors including ErrChecksumMismatch are returned by Read instead of io.EOF.
+// Close the reader to stop downloading
+// Open 返回基于 DownloadTo 的 opts.FileURL 读取器，包括 ErrChecksumMismatch 在内的错误会代替 io.EOF 由 Read 返回，关闭读取器即停止下载
+func (t *HttpDownloader) Open(ctx context.Context, opts *DownloadOpts) (rc io.ReadCloser, err error) {
+	if opts.Checksum != "" {
+		if _, _, err = parseChecksum(opts.Checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(t.DownloadTo(ctx, opts, pw))
+	}()
+	return &downloadReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// downloadReader 关闭时同时取消下载
+type downloadReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (t *downloadReader) Close() error {
+	t.cancel()
+	return t.PipeReader.Close()
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpDownloadTo(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	server := newTestServer(t, content, nil)
+	defer server.Close()
+
+	var attempts []RetryAttempt
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{RetryPolicy: &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		OnRetry: func(attempt RetryAttempt) {
+			attempts = append(attempts, attempt)
+		},
+	}})
+	assert.Equal(t, nil, err)
+	dl.WorkerCount = 4
+
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	// 连接中断后从已经写出的位置继续
+	server.SetDropAfter(10000)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.SetDropAfter(0)
+	}()
+	var buf bytes.Buffer
+	err = dl.DownloadTo(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", Checksum: checksum}, &buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf.Bytes()))
+	assert.Equal(t, 1, len(attempts))
+	assert.Equal(t, int64(10000), attempts[0].Offset)
+
+	rc, err := dl.Open(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", Checksum: checksum})
+	assert.Equal(t, nil, err)
+	data, err := io.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, data))
+	assert.Equal(t, nil, rc.Close())
+
+	rc, err = dl.Open(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", Checksum: "sha256:" + hex.EncodeToString(make([]byte, 32))})
+	assert.Equal(t, nil, err)
+	_, err = io.ReadAll(rc)
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+
+	_, err = dl.Open(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", Checksum: "unknown:00"})
+	assert.Equal(t, true, errors.Is(err, ErrUnknownChecksumType))
+
+	// 已经写出的数据无法撤回，远程文件变化时返回错误
+	attempts = nil
+	server.SetDropAfter(10000)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.writeFile(t, "file", bytes.Repeat([]byte("x"), len(content)))
+		server.SetDropAfter(0)
+	}()
+	buf.Reset()
+	err = dl.DownloadTo(context.Background(), &DownloadOpts{FileURL: server.URL + "/file"}, &buf)
+	assert.Equal(t, true, errors.Is(err, ErrRemoteFileChanged))
+}
@@ -0,0 +1,246 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileServerOpts options of FileServer
+// FileServerOpts FileServer 的选项
+type FileServerOpts struct {
+	// Dir directory to serve, directory listing is not provided
+	// Dir 提供下载的目录，不提供目录列表
+	Dir string
+	// RateLimiter cap total bandwidth of all responses, nil means unlimited
+	// RateLimiter 限制所有响应的总带宽，为空时不限速
+	RateLimiter *RateLimiter
+	// DropAfter close connection after sending DropAfter bytes of body in every response, 0 means never
+	// DropAfter 每个响应发送 DropAfter 字节的响应体后断开连接，0 表示不断开
+	DropAfter int64
+	// ErrorRate probability between 0 and 1 of answering a request with ErrorStatus
+	// ErrorRate 以 ErrorStatus 响应请求的概率，取值 0 到 1
+	ErrorRate float64
+	// ErrorStatus status code of injected errors, default is 503
+	// ErrorStatus 注入错误的状态码，默认 503
+	ErrorStatus int
+	// Seed seed of ErrorRate random source, the same seed injects errors into the same requests
+	// Seed ErrorRate 随机数的种子，相同的种子会在相同的请求上注入错误
+	Seed int64
+	// DisableRange ignore Range and always send the whole file like servers without range support
+	// DisableRange 忽略 Range 总是发送完整文件，模拟不支持 Range 的服务器
+	DisableRange bool
+}
+
+// FileServer serve files of a directory with Range, If-Range, ETag, Last-Modified, Content-Digest and Repr-Digest, ETag is derived from sha256 of content
+// so the same file served from different hosts has the same ETag. Bandwidth limit and fault injection make resume and retry of HttpDownloader reproducible
+// FileServer 提供目录中文件的下载，支持 Range、If-Range、ETag、Last-Modified、Content-Digest 和 Repr-Digest，ETag 由内容的 sha256 生成，
+// 不同主机提供的相同文件 ETag 相同。带宽限制和故障注入可以稳定复现 HttpDownloader 的续传和重试
+type FileServer struct {
+	opts FileServerOpts
+
+	lock     sync.Mutex
+	rand     *rand.Rand
+	failNext int
+	digests  map[string]fileDigest
+}
+
+// fileDigest 文件内容的 sha256，文件大小或修改时间变化后重新计算
+type fileDigest struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// NewFileServer create file server of opts.Dir, opts is copied, use the setters to change fault injection of a running server
+// NewFileServer 新建提供 opts.Dir 下载的文件服务器，opts 会被复制，运行中修改故障注入需要使用 Set 开头的方法
+func NewFileServer(opts *FileServerOpts) (t *FileServer) {
+	t = new(FileServer)
+	t.opts = *opts
+	t.rand = rand.New(rand.NewSource(opts.Seed))
+	t.digests = make(map[string]fileDigest)
+	return t
+}
+
+func (t *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	opts, fail := t.snapshot()
+	if fail {
+		status := opts.ErrorStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	// http.Dir 会拒绝包含 .. 的路径
+	name := path.Clean("/" + r.URL.Path)
+	f, err := http.Dir(opts.Dir).Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	sum, err := t.digest(name, f, fi)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Repr-Digest 是完整文件的摘要，Content-Digest 是本次响应体的摘要，只有完整响应时两者相同
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum)+`"`)
+	w.Header().Set("Repr-Digest", digest)
+	if r.Header.Get("Range") == "" || opts.DisableRange {
+		w.Header().Set("Content-Digest", digest)
+	}
+
+	if opts.RateLimiter != nil || opts.DropAfter > 0 {
+		w = &faultResponseWriter{ResponseWriter: w, r: r, limiter: opts.RateLimiter, remaining: opts.DropAfter}
+	}
+	if opts.DisableRange {
+		// http.ServeContent 总是声明支持 Range，这里直接发送完整文件
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+		if r.Method != http.MethodHead {
+			io.Copy(w, f)
+		}
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// SetDropAfter change DropAfter of subsequent requests
+// SetDropAfter 修改之后的请求的 DropAfter
+func (t *FileServer) SetDropAfter(n int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.opts.DropAfter = n
+}
+
+// SetErrorRate change ErrorRate of subsequent requests and reseed the random source, the same seed injects errors into the same requests from now on
+// SetErrorRate 修改之后的请求的 ErrorRate 并重新设置随机数种子，从现在开始相同的种子会在相同的请求上注入错误
+func (t *FileServer) SetErrorRate(rate float64, seed int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.opts.ErrorRate = rate
+	t.opts.Seed = seed
+	t.rand = rand.New(rand.NewSource(seed))
+}
+
+// SetRateLimiter change RateLimiter of subsequent requests, nil means unlimited
+// SetRateLimiter 修改之后的请求的 RateLimiter，为空时不限速
+func (t *FileServer) SetRateLimiter(limiter *RateLimiter) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.opts.RateLimiter = limiter
+}
+
+// FailNext answer the next n requests with ErrorStatus regardless of ErrorRate
+// FailNext 无论 ErrorRate 是多少，接下来的 n 个请求都以 ErrorStatus 响应
+func (t *FileServer) FailNext(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.failNext = n
+}
+
+// snapshot 返回本次请求使用的选项，并按照 FailNext 和 ErrorRate 决定本次请求是否返回错误
+func (t *FileServer) snapshot() (opts FileServerOpts, fail bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.failNext > 0 {
+		t.failNext--
+		return t.opts, true
+	}
+	return t.opts, t.opts.ErrorRate > 0 && t.rand.Float64() < t.opts.ErrorRate
+}
+
+// digest 返回文件内容的 sha256，文件没有变化时使用上次计算的结果
+func (t *FileServer) digest(name string, f io.Reader, fi os.FileInfo) (sum []byte, err error) {
+	t.lock.Lock()
+	cached, ok := t.digests[name]
+	t.lock.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.sum, nil
+	}
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	sum = hash.Sum(nil)
+
+	t.lock.Lock()
+	t.digests[name] = fileDigest{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	t.lock.Unlock()
+	return sum, nil
+}
+
+// faultResponseWriter 按照限速器发送响应体，发送 remaining 个字节后中断连接
+type faultResponseWriter struct {
+	http.ResponseWriter
+	r         *http.Request
+	limiter   *RateLimiter
+	remaining int64
+}
+
+func (t *faultResponseWriter) Write(p []byte) (n int, err error) {
+	drop := false
+	if t.remaining > 0 && int64(len(p)) >= t.remaining {
+		p, drop = p[:t.remaining], true
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if t.limiter != nil {
+			if size := t.limiter.chunkSize(); size > 0 && len(chunk) > size {
+				chunk = chunk[:size]
+			}
+			if err = t.limiter.WaitN(t.r.Context(), len(chunk)); err != nil {
+				return n, err
+			}
+		}
+		written, err := t.ResponseWriter.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+
+	if t.remaining > 0 {
+		t.remaining -= int64(n)
+	}
+	if drop {
+		// 先把已经写入的数据发送出去，再中断连接，客户端会读取到 unexpected EOF
+		if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return n, nil
+}
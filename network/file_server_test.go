@@ -0,0 +1,81 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileServer(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.Equal(t, nil, os.WriteFile(filepath.Join(dir, "sub", "file"), content, 0644))
+
+	fs := NewFileServer(&FileServerOpts{Dir: dir})
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sub/file")
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	assert.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, resp.Header.Get("ETag"))
+	assert.Equal(t, digest, resp.Header.Get("Content-Digest"))
+	assert.Equal(t, digest, resp.Header.Get("Repr-Digest"))
+
+	req, _ := http.NewRequest("GET", server.URL+"/sub/file", nil)
+	req.Header.Set("Range", "bytes=16-31")
+	req.Header.Set("If-Range", resp.Header.Get("ETag"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Digest"))
+	assert.Equal(t, digest, resp.Header.Get("Repr-Digest"))
+
+	for _, name := range []string{"/sub", "/missing", "/../" + filepath.Base(dir) + "/sub/file"} {
+		resp, err = http.Get(server.URL + name)
+		assert.Equal(t, nil, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, name)
+	}
+
+	// 每个响应都在发送一部分数据后断开，并且随机返回 503，下载器依靠续传和重试完成下载
+	fs.SetDropAfter(40 * 1024)
+	fs.SetErrorRate(0.3, 1)
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{RetryPolicy: &RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Millisecond}})
+	assert.Equal(t, nil, err)
+	dl.WorkerCount = 2
+	dl.MinSegmentSize = 64 * 1024
+	var retries atomic.Int32
+	dl.Opts.RetryPolicy.OnRetry = func(attempt RetryAttempt) { retries.Add(1) }
+	output := filepath.Join(t.TempDir(), "file")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/sub/file", OutputFilename: output, Checksum: "sha256:" + hex.EncodeToString(sum[:])})
+	assert.Equal(t, nil, err)
+	assert.Greater(t, retries.Load(), int32(4))
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, buf)
+
+	// 带宽限制
+	fs.SetDropAfter(0)
+	fs.SetErrorRate(0, 0)
+	fs.SetRateLimiter(NewRateLimiter(512*1024, 32*1024))
+	start := time.Now()
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/sub/file", OutputFilename: filepath.Join(t.TempDir(), "file")})
+	assert.Equal(t, nil, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
@@ -1,8 +1,16 @@
 package network
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,3 +35,343 @@ func TestHttpDownload(t *testing.T) {
 	err = dl.Download(ctx, &DownloadOpts{FileURL: "https://dl.k8s.io/v1.23.3/kubernetes-node-linux-arm64.tar.gz", OutputFilename: "b:/kubernetes-node-linux-arm64.tar.gz", Checksum: "sha256:" + string(buf)})
 	assert.Equal(t, nil, err)
 }
+
+// testModTime 测试文件第一次写入时的修改时间，之后每次写入晚一秒
+var testModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testServer 测试用文件服务器，在 FileServer 之上记录请求的方法和 Range，可以替换目录中的文件
+type testServer struct {
+	*httptest.Server
+	*FileServer
+	dir           string
+	lock          sync.Mutex
+	modTime       time.Time
+	rangeRequests []string
+	// methods 每个请求的方法
+	methods []string
+}
+
+// newTestServer 新建测试文件服务器，content 不为空时作为 /file 的内容，opts 为空时使用默认选项
+func newTestServer(t *testing.T, content []byte, opts *FileServerOpts) (server *testServer) {
+	if opts == nil {
+		opts = &FileServerOpts{}
+	}
+	server = &testServer{dir: t.TempDir(), modTime: testModTime}
+	opts.Dir = server.dir
+	if content != nil {
+		server.writeFile(t, "file", content)
+	}
+	server.FileServer = NewFileServer(opts)
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return
+}
+
+func (t *testServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t.lock.Lock()
+	if r.Header.Get("Range") != "" && r.Header.Get("Range") != "bytes=0-0" {
+		t.rangeRequests = append(t.rangeRequests, r.Header.Get("Range"))
+	}
+	t.methods = append(t.methods, r.Method)
+	t.lock.Unlock()
+	t.FileServer.ServeHTTP(w, r)
+}
+
+// writeFile 原子地替换 name 的内容，每次写入的修改时间都比上一次晚，FileServer 会重新计算 ETag
+func (t *testServer) writeFile(tb testing.TB, name string, content []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	filename := filepath.Join(t.dir, name)
+	assert.Equal(tb, nil, os.WriteFile(filename+".tmp", content, 0644))
+	assert.Equal(tb, nil, os.Chtimes(filename+".tmp", t.modTime, t.modTime))
+	assert.Equal(tb, nil, os.Rename(filename+".tmp", filename))
+	t.modTime = t.modTime.Add(time.Second)
+}
+
+func (t *testServer) takeRangeRequests() (ranges []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ranges = t.rangeRequests
+	t.rangeRequests = nil
+	return
+}
+
+// newTestDownload 准备一次下载测试：size 字节的测试内容作为 /file，下载器使用 workers 个协程按 32KiB 起分段，输出到临时文件 output，
+// opts 和 serverOpts 为空时使用默认选项，测试结束时关闭服务器
+func newTestDownload(t *testing.T, size int, workers int, opts *HttpDownloaderOpts, serverOpts *FileServerOpts) (dl *HttpDownloader, server *testServer, content []byte, output string) {
+	content = bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	server = newTestServer(t, content, serverOpts)
+	t.Cleanup(server.Close)
+
+	if opts == nil {
+		opts = &HttpDownloaderOpts{}
+	}
+	dl, err := NewHttpDownloader(opts)
+	assert.Equal(t, nil, err)
+	dl.WorkerCount = workers
+	dl.MinSegmentSize = 32 * 1024
+	output = filepath.Join(t.TempDir(), "file")
+	return
+}
+
+func TestHttpDownloadSegments(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 1024*1024, 4, nil, nil)
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(server.takeRangeRequests()))
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+}
+
+func TestHttpDownloadRangeUnsupported(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 1024*1024, 4, nil, &FileServerOpts{DisableRange: true})
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+}
+
+func TestHttpDownloadResume(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 1024*1024, 1, nil, nil)
+	server.SetDropAfter(100000)
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, fileExists(manifestFilename(output)))
+
+	server.SetDropAfter(0)
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"bytes=100000-1048575"}, server.takeRangeRequests())
+	assert.Equal(t, false, fileExists(manifestFilename(output)))
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+}
+
+func TestHttpDownloadResumeRemoteChanged(t *testing.T) {
+	dl, server, _, output := newTestDownload(t, 1024*1024, 4, nil, nil)
+	server.SetDropAfter(1000)
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, fileExists(manifestFilename(output)))
+
+	changed := bytes.Repeat([]byte("fedcba9876543210"), 48*1024)
+	server.writeFile(t, "file", changed)
+	server.SetDropAfter(0)
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(changed, buf))
+}
+
+func TestHttpDownloadChecksum(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 1024*1024, 4, nil, nil)
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output, Checksum: checksum})
+	assert.Equal(t, nil, err)
+
+	// 已存在且校验通过的文件不会再下载
+	server.Close()
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output, Checksum: checksum})
+	assert.Equal(t, nil, err)
+
+	server = newTestServer(t, content, nil)
+	defer server.Close()
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output, Checksum: "sha512:" + hex.EncodeToString(sum[:])})
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestHttpDownloadRetry(t *testing.T) {
+	var attempts []RetryAttempt
+	dl, server, content, output := newTestDownload(t, 1024*1024, 1, &HttpDownloaderOpts{RetryPolicy: &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Jitter:         0.5,
+		OnRetry: func(attempt RetryAttempt) {
+			attempts = append(attempts, attempt)
+		},
+	}}, nil)
+	server.FailNext(2)
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(attempts))
+	assert.Equal(t, 3, attempts[1].Attempt)
+
+	// 连接中断后从已写入的位置继续
+	attempts = nil
+	os.Remove(output)
+	server.SetDropAfter(100000)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		server.SetDropAfter(0)
+	}()
+	dl.Opts.RetryPolicy.InitialBackoff = 200 * time.Millisecond
+	dl.Opts.RetryPolicy.Jitter = 0
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(attempts))
+	assert.Equal(t, int64(100000), attempts[0].Offset)
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+
+	server.FailNext(3)
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(t.TempDir(), "file")})
+	var statusErr *HttpStatusError
+	assert.Equal(t, true, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+}
+
+func TestHttpDownloadRateLimit(t *testing.T) {
+	dl, server, _, output := newTestDownload(t, 256*1024, 4, &HttpDownloaderOpts{RateLimiter: NewRateLimiter(512*1024, 32*1024)}, nil)
+	start := time.Now()
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output})
+	assert.Equal(t, nil, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestHttpDownloadProgress(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 256*1024, 2, &HttpDownloaderOpts{
+		RateLimiter:      NewRateLimiter(1024*1024, 32*1024),
+		ProgressInterval: 20 * time.Millisecond,
+	}, nil)
+
+	var reports []Progress
+	progressChan := make(chan Progress)
+	err := dl.Download(context.Background(), &DownloadOpts{
+		FileURL:        server.URL + "/file",
+		OutputFilename: output,
+		// 没有人读取的通道不会阻塞下载
+		ProgressChan: progressChan,
+		ProgressReporter: ProgressReporterFunc(func(progress Progress) {
+			reports = append(reports, progress)
+		}),
+	})
+	assert.Equal(t, nil, err)
+	assert.Greater(t, len(reports), 2)
+
+	last := reports[len(reports)-1]
+	assert.Equal(t, true, last.Done)
+	assert.Equal(t, int64(len(content)), last.TotalSize)
+	assert.Equal(t, int64(len(content)), last.BytesDone)
+	assert.Equal(t, float64(100), last.Percent())
+	assert.Equal(t, 2, len(last.Segments))
+	assert.Equal(t, SegmentStateDone, last.Segments[0].State)
+	assert.Greater(t, last.AverageSpeed, float64(0))
+	assert.Equal(t, false, reports[0].Done)
+}
+
+func TestHttpDownloadTotalSizeChan(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 256*1024, 2, nil, nil)
+
+	// 有缓冲的通道可以收到文件大小
+	totalSizeChan := make(chan int64, 1)
+	err := dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output, TotalSizeChan: totalSizeChan})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(content)), <-totalSizeChan)
+
+	// 没有接收方时不会阻塞下载
+	done := make(chan error, 1)
+	go func() {
+		done <- dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: filepath.Join(t.TempDir(), "file"), TotalSizeChan: make(chan int64)})
+	}()
+	select {
+	case err = <-done:
+		assert.Equal(t, nil, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "download blocked on TotalSizeChan")
+	}
+}
+
+func TestHttpDownloadAtomic(t *testing.T) {
+	dl, server, content, output := newTestDownload(t, 256*1024, 2, &HttpDownloaderOpts{ProgressInterval: time.Millisecond}, nil)
+	sum := sha256.Sum256(content)
+	var outputVisible bool
+	err := dl.Download(context.Background(), &DownloadOpts{
+		FileURL:         server.URL + "/file",
+		OutputFilename:  output,
+		Checksum:        "sha256:" + hex.EncodeToString(sum[:]),
+		Atomic:          true,
+		PreserveModTime: true,
+		ProgressReporter: ProgressReporterFunc(func(progress Progress) {
+			outputVisible = outputVisible || fileExists(output)
+		}),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, outputVisible)
+	assert.Equal(t, false, fileExists(partFilename(output)))
+
+	fi, err := os.Stat(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, fi.ModTime().Equal(testModTime))
+
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+
+	// 校验失败时不会覆盖已有文件，也不会留下临时文件
+	server.writeFile(t, "file", bytes.Repeat([]byte("x"), len(content)))
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL + "/file", OutputFilename: output, Checksum: "sha256:" + hex.EncodeToString(make([]byte, 32)), Atomic: true})
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, false, fileExists(partFilename(output)))
+	buf, err = os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+}
+
+func TestHttpDownloadMirrors(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	primary := newTestServer(t, content, nil)
+	defer primary.Close()
+	mirror := newTestServer(t, content, nil)
+	defer mirror.Close()
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	dir := t.TempDir()
+
+	// 主地址不可用时切换到镜像
+	dl.WorkerCount = 2
+	dl.MinSegmentSize = 32 * 1024
+	output := filepath.Join(dir, "failover")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: notFound.URL, Mirrors: []string{mirror.URL + "/file"}, OutputFilename: output, Checksum: checksum})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(mirror.takeRangeRequests()))
+
+	// 传输中断后从已经下载到的位置切换到镜像
+	dl.WorkerCount = 1
+	primary.SetDropAfter(1000)
+	output = filepath.Join(dir, "resume")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: primary.URL + "/file", Mirrors: []string{mirror.URL + "/file"}, OutputFilename: output, Checksum: checksum})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"bytes=1000-262143"}, mirror.takeRangeRequests())
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.Equal(content, buf))
+
+	// 同时从多个下载源下载不同的分段
+	primary.SetDropAfter(0)
+	dl.WorkerCount = 4
+	output = filepath.Join(dir, "multi")
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: primary.URL + "/file", Mirrors: []string{mirror.URL + "/file"}, MultiSource: true, OutputFilename: output, Checksum: checksum})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(primary.takeRangeRequests()))
+	assert.Equal(t, 2, len(mirror.takeRangeRequests()))
+
+	// 镜像内容不一致时拼接后的结果无法通过校验
+	mirror.writeFile(t, "file", bytes.Repeat([]byte("x"), len(content)))
+	err = dl.Download(context.Background(), &DownloadOpts{FileURL: primary.URL + "/file", Mirrors: []string{mirror.URL + "/file"}, MultiSource: true, OutputFilename: filepath.Join(dir, "corrupt"), Checksum: checksum})
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+}
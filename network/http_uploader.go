@@ -0,0 +1,558 @@
+package network
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownUploadMethod UploadOpts.Method is not one of the UploadMethod constants
+// ErrUnknownUploadMethod UploadOpts.Method 不是 UploadMethod 中的常量
+var ErrUnknownUploadMethod = errors.New("unknown upload method")
+
+// ErrUploadOffsetMismatch tus server reported an offset which does not match the uploaded bytes
+// ErrUploadOffsetMismatch tus 服务器返回的偏移与已经上传的字节数对不上
+var ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+
+// ErrUploadLengthMismatch tus upload to resume was created for a file of another size
+// ErrUploadLengthMismatch 要继续的 tus 上传是为另一个大小的文件创建的
+var ErrUploadLengthMismatch = errors.New("upload length mismatch")
+
+// UploadMethod how the file is sent to server
+// UploadMethod 文件发送到服务器的方式
+type UploadMethod = string
+
+const (
+	// UploadMethodMultipart POST file as a multipart/form-data field, the whole file is sent again when retrying
+	// UploadMethodMultipart 以 multipart/form-data 字段 POST 文件，重试时重新发送整个文件
+	UploadMethodMultipart UploadMethod = "multipart"
+	// UploadMethodPut PUT file in chunks of ChunkSize with Content-Range, only the failed chunk is sent again when retrying
+	// UploadMethodPut 以 ChunkSize 为单位带 Content-Range 分块 PUT 文件，重试时只重新发送失败的分块
+	UploadMethodPut UploadMethod = "put"
+	// UploadMethodTus tus resumable upload protocol 1.0.0, retrying resumes from the offset confirmed by server
+	// UploadMethodTus tus 1.0.0 断点续传上传协议，重试时从服务器确认的偏移继续
+	UploadMethodTus UploadMethod = "tus"
+)
+
+// defaultUploadChunkSize 分块上传时每个分块的默认字节数
+const defaultUploadChunkSize int64 = 8 << 20
+
+// tusVersion 使用的 tus 协议版本
+const tusVersion = "1.0.0"
+
+// maxTusConflicts PATCH 连续返回 409 的最大次数，超过后返回错误
+const maxTusConflicts = 3
+
+type UploadOpts struct {
+	// URL upload address, it is the creation endpoint when Method is UploadMethodTus
+	// URL 上传地址，Method 为 UploadMethodTus 时为创建上传的地址
+	URL string
+	// InputFilename file to upload
+	// InputFilename 要上传的文件
+	InputFilename string
+	// Method default is UploadMethodMultipart
+	// Method 上传方式，默认 UploadMethodMultipart
+	Method UploadMethod
+	// FieldName form field of the file when Method is UploadMethodMultipart, default is file
+	// FieldName Method 为 UploadMethodMultipart 时文件所在的表单字段，默认 file
+	FieldName string
+	// Fields extra form fields sent before the file when Method is UploadMethodMultipart
+	// Fields Method 为 UploadMethodMultipart 时在文件之前发送的其他表单字段
+	Fields map[string]string
+	// ChunkSize bytes of each request when Method is UploadMethodPut or UploadMethodTus, default is 8MiB.
+	// A file not larger than ChunkSize is sent by a single PUT without Content-Range
+	// ChunkSize Method 为 UploadMethodPut 或 UploadMethodTus 时每个请求发送的字节数，默认 8MiB。不大于 ChunkSize 的文件以不带 Content-Range 的单个 PUT 发送
+	ChunkSize int64
+	// Metadata tus Upload-Metadata, filename is set to base name of InputFilename if missing
+	// Metadata tus 的 Upload-Metadata，没有 filename 时使用 InputFilename 的文件名
+	Metadata map[string]string
+	// UploadURL resume an upload created before by UploadMethodTus, usually the location returned by an interrupted Upload
+	// UploadURL 继续之前以 UploadMethodTus 创建的上传，通常是中断的 Upload 返回的地址
+	UploadURL string
+	// Header extra request headers of this upload
+	// Header 本次上传附加的请求头
+	Header http.Header
+	// Credentials credentials of this upload, overrides HttpDownloaderOpts.Credentials
+	// Credentials 本次上传使用的认证信息，会覆盖 HttpDownloaderOpts.Credentials
+	Credentials CredentialProvider
+	// ProgressReporter receive progress every HttpDownloaderOpts.ProgressInterval and once more when upload finished,
+	// Progress.URL is URL and Progress.OutputFilename is InputFilename
+	// ProgressReporter 每隔 HttpDownloaderOpts.ProgressInterval 接收一次进度，上传结束时再接收一次，Progress.URL 为 URL，Progress.OutputFilename 为 InputFilename
+	ProgressReporter ProgressReporter
+	// ProgressChan same as ProgressReporter but progress is dropped instead of blocking upload when channel is full
+	// ProgressChan 与 ProgressReporter 相同，但通道已满时会丢弃进度而不是阻塞上传
+	ProgressChan chan<- Progress
+}
+
+func (t *UploadOpts) fieldName() string {
+	if t.FieldName != "" {
+		return t.FieldName
+	}
+	return "file"
+}
+
+func (t *UploadOpts) chunkSize() int64 {
+	if t.ChunkSize > 0 {
+		return t.ChunkSize
+	}
+	return defaultUploadChunkSize
+}
+
+// HttpUploader upload counterpart of HttpDownloader, proxy, TLS, retry, rate limit, credentials and progress interval come from the same HttpDownloaderOpts
+// HttpUploader 与 HttpDownloader 对应的上传器，代理、TLS、重试、限速、认证和进度间隔使用相同的 HttpDownloaderOpts
+type HttpUploader struct {
+	Opts *HttpDownloaderOpts
+
+	// downloader 共用重试、认证和进度报告的实现
+	downloader *HttpDownloader
+}
+
+func NewHttpUploader(opts *HttpDownloaderOpts) (t *HttpUploader, err error) {
+	downloader, err := NewHttpDownloader(opts)
+	if err != nil {
+		return nil, err
+	}
+	t = new(HttpUploader)
+	t.Opts = opts
+	t.downloader = downloader
+	return
+}
+
+// uploadTask 一次上传过程中的运行状态，进度借用只有一个分段的下载清单计算
+type uploadTask struct {
+	opts     *UploadOpts
+	file     *os.File
+	size     int64
+	download *downloadTask
+	seg      *segment
+}
+
+// setSent 将已经发送的字节数设置为 offset，失败重发时回退到服务器已经确认的位置
+func (t *uploadTask) setSent(offset int64) {
+	m := t.download.manifest
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t.seg.Written = offset
+}
+
+func (t *uploadTask) sent() int64 {
+	m := t.download.manifest
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return t.seg.Written
+}
+
+// uploadProgressReader 读取文件的同时累加已经发送的字节数
+type uploadProgressReader struct {
+	r    io.Reader
+	task *uploadTask
+}
+
+func (t *uploadProgressReader) Read(p []byte) (n int, err error) {
+	n, err = t.r.Read(p)
+	m := t.task.download.manifest
+	m.lock.Lock()
+	t.task.seg.Written += int64(n)
+	m.lock.Unlock()
+	return
+}
+
+// Upload upload opts.InputFilename by opts.Method and return Location responded by server, for UploadMethodTus it is the upload URL
+// which can be passed as UploadOpts.UploadURL to resume an interrupted upload
+// Upload 按照 opts.Method 上传 opts.InputFilename，返回服务器响应的 Location，UploadMethodTus 时为上传地址，可以作为 UploadOpts.UploadURL 继续中断的上传
+func (t *HttpUploader) Upload(ctx context.Context, opts *UploadOpts) (location string, err error) {
+	var upload func(ctx context.Context, task *uploadTask) (string, error)
+	switch opts.Method {
+	case "", UploadMethodMultipart:
+		upload = t.uploadMultipart
+	case UploadMethodPut:
+		upload = t.uploadChunks
+	case UploadMethodTus:
+		upload = t.uploadTus
+	default:
+		return "", fmt.Errorf("%w %s", ErrUnknownUploadMethod, opts.Method)
+	}
+
+	f, err := os.Open(opts.InputFilename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	task := &uploadTask{opts: opts, file: f, size: fi.Size()}
+	task.seg = &segment{Start: 0, End: task.size - 1, state: SegmentStateDownloading}
+	task.download = &downloadTask{
+		opts: &DownloadOpts{
+			FileURL:          opts.URL,
+			OutputFilename:   opts.InputFilename,
+			ProgressReporter: opts.ProgressReporter,
+			ProgressChan:     opts.ProgressChan,
+		},
+		manifest: &downloadManifest{URL: opts.URL, TotalSize: task.size, Segments: []*segment{task.seg}},
+	}
+	task.download.progress = newProgressTracker(task.download)
+
+	progressDone := make(chan struct{})
+	progressStopped := make(chan struct{})
+	go func() {
+		defer close(progressStopped)
+		ticker := time.NewTicker(t.downloader.progressInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progressDone:
+				return
+			case <-ticker.C:
+				task.download.progress.report(false)
+			}
+		}
+	}()
+
+	location, err = upload(ctx, task)
+
+	close(progressDone)
+	<-progressStopped
+	state := SegmentStateDone
+	if err != nil {
+		state = SegmentStateFailed
+	}
+	task.download.manifest.setState(task.seg, state)
+	task.download.progress.report(true)
+	return location, err
+}
+
+// uploadMultipart 以 multipart/form-data POST 文件，请求体边生成边发送，重试时重新生成
+func (t *HttpUploader) uploadMultipart(ctx context.Context, task *uploadTask) (location string, err error) {
+	// 每次生成的请求体都要使用相同的分隔符，否则与 Content-Type 对不上
+	mw := multipart.NewWriter(io.Discard)
+	boundary, contentType := mw.Boundary(), mw.FormDataContentType()
+
+	err = t.downloader.withRetry(ctx, task.opts.URL, task.sent, func() error {
+		newBody := func() (io.ReadCloser, error) {
+			task.setSent(0)
+			return t.multipartBody(ctx, task, boundary), nil
+		}
+		body, _ := newBody()
+		req, err := t.newRequest(ctx, task.opts, http.MethodPost, task.opts.URL, body)
+		if err != nil {
+			body.Close()
+			return err
+		}
+		req.GetBody = newBody
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := t.send(req, task.opts, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		location = resolveLocation(task.opts.URL, resp.Header.Get("Location"))
+		return nil
+	})
+	return location, err
+}
+
+// multipartBody 在协程中生成 multipart 请求体，请求失败时传输层会关闭请求体，协程随之退出
+func (t *HttpUploader) multipartBody(ctx context.Context, task *uploadTask, boundary string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		mw := multipart.NewWriter(pw)
+		err := mw.SetBoundary(boundary)
+		if err == nil {
+			err = t.writeMultipart(ctx, task, mw)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func (t *HttpUploader) writeMultipart(ctx context.Context, task *uploadTask, mw *multipart.Writer) (err error) {
+	// 按照字段名排序，保证每次发送的请求体相同
+	names := make([]string, 0, len(task.opts.Fields))
+	for name := range task.opts.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = mw.WriteField(name, task.opts.Fields[name]); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile(task.opts.fieldName(), filepath.Base(task.opts.InputFilename))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, t.body(ctx, task, 0, task.size)); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// uploadChunks 以 Content-Range 分块 PUT 文件，每个分块单独重试，文件不大于一个分块时以普通 PUT 发送
+func (t *HttpUploader) uploadChunks(ctx context.Context, task *uploadTask) (location string, err error) {
+	chunkSize := task.opts.chunkSize()
+	for offset := int64(0); ; {
+		n := min(chunkSize, task.size-offset)
+		err = t.downloader.withRetry(ctx, task.opts.URL, task.sent, func() error {
+			req, err := t.newChunkRequest(ctx, task, http.MethodPut, task.opts.URL, offset, n)
+			if err != nil {
+				return err
+			}
+			if task.size > chunkSize {
+				req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, task.size))
+			}
+
+			// 308 表示服务器已经收到分块，等待后续分块
+			resp, err := t.send(req, task.opts, http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusPermanentRedirect)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			if loc := resp.Header.Get("Location"); loc != "" && resp.StatusCode != http.StatusPermanentRedirect {
+				location = resolveLocation(task.opts.URL, loc)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		offset += n
+		if offset >= task.size {
+			return location, nil
+		}
+	}
+}
+
+// uploadTus 按照 tus 协议创建或继续上传，PATCH 失败后先用 HEAD 查询服务器已经收到的偏移，再从该偏移继续
+func (t *HttpUploader) uploadTus(ctx context.Context, task *uploadTask) (location string, err error) {
+	uploadURL := task.opts.UploadURL
+	var offset int64
+	if uploadURL != "" {
+		err = t.downloader.withRetry(ctx, uploadURL, nil, func() (err error) {
+			offset, err = t.tusOffset(ctx, task, uploadURL)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		task.setSent(offset)
+		task.download.progress.reset()
+	} else {
+		err = t.downloader.withRetry(ctx, task.opts.URL, nil, func() (err error) {
+			uploadURL, err = t.tusCreate(ctx, task)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	stale, conflicts := false, 0
+	for offset < task.size {
+		err = t.downloader.withRetry(ctx, uploadURL, task.sent, func() (err error) {
+			if stale {
+				if offset, err = t.tusOffset(ctx, task, uploadURL); err != nil {
+					return err
+				}
+				task.setSent(offset)
+				stale = false
+				if offset >= task.size {
+					return nil
+				}
+			}
+			if offset, err = t.tusPatch(ctx, task, uploadURL, offset); err != nil {
+				stale = true
+			}
+			return err
+		})
+		// 409 表示偏移与服务器不一致，不占用重试次数，下一次循环先查询服务器的偏移再继续
+		var statusErr *HttpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict && conflicts < maxTusConflicts {
+			conflicts++
+			continue
+		}
+		if err != nil {
+			// 返回上传地址，调用方可以用它继续上传
+			return uploadURL, err
+		}
+		conflicts = 0
+	}
+	return uploadURL, nil
+}
+
+// tusCreate 创建上传，返回服务器分配的上传地址
+func (t *HttpUploader) tusCreate(ctx context.Context, task *uploadTask) (uploadURL string, err error) {
+	req, err := t.newRequest(ctx, task.opts, http.MethodPost, task.opts.URL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(task.size, 10))
+	req.Header.Set("Upload-Metadata", tusMetadata(task.opts))
+
+	resp, err := t.send(req, task.opts, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("tus server %s responded without Location", task.opts.URL)
+	}
+	return resolveLocation(task.opts.URL, location), nil
+}
+
+// tusOffset 查询服务器已经收到的字节数
+func (t *HttpUploader) tusOffset(ctx context.Context, task *uploadTask, uploadURL string) (offset int64, err error) {
+	req, err := t.newRequest(ctx, task.opts, http.MethodHead, uploadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+
+	resp, err := t.send(req, task.opts, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || offset > task.size {
+		return 0, fmt.Errorf("%w: %s responded Upload-Offset %q", ErrUploadOffsetMismatch, uploadURL, resp.Header.Get("Upload-Offset"))
+	}
+	if length := resp.Header.Get("Upload-Length"); length != "" && length != strconv.FormatInt(task.size, 10) {
+		return 0, fmt.Errorf("%w: %s responded Upload-Length %s but file size is %d", ErrUploadLengthMismatch, uploadURL, length, task.size)
+	}
+	return offset, nil
+}
+
+// tusPatch 从 offset 发送一个分块，返回服务器确认的新偏移
+func (t *HttpUploader) tusPatch(ctx context.Context, task *uploadTask, uploadURL string, offset int64) (newOffset int64, err error) {
+	n := min(task.opts.chunkSize(), task.size-offset)
+	req, err := t.newChunkRequest(ctx, task, http.MethodPatch, uploadURL, offset, n)
+	if err != nil {
+		return offset, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+
+	resp, err := t.send(req, task.opts, http.StatusNoContent)
+	if err != nil {
+		// 409 表示偏移与服务器不一致，由 uploadTus 查询偏移后继续
+		var statusErr *HttpStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+			err = fmt.Errorf("%w: %w", ErrUploadOffsetMismatch, err)
+		}
+		return offset, err
+	}
+	resp.Body.Close()
+
+	newOffset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || newOffset <= offset || newOffset > offset+n {
+		return offset, fmt.Errorf("%w: sent %d bytes from %d but %s responded Upload-Offset %q", ErrUploadOffsetMismatch, n, offset, uploadURL, resp.Header.Get("Upload-Offset"))
+	}
+	return newOffset, nil
+}
+
+// newChunkRequest 新建发送文件 [offset, offset+n) 的请求，请求体可以在认证刷新后重新生成
+func (t *HttpUploader) newChunkRequest(ctx context.Context, task *uploadTask, method string, uploadURL string, offset int64, n int64) (req *http.Request, err error) {
+	newBody := func() (io.ReadCloser, error) {
+		task.setSent(offset)
+		if n <= 0 {
+			return http.NoBody, nil
+		}
+		return io.NopCloser(t.body(ctx, task, offset, n)), nil
+	}
+	body, _ := newBody()
+	if req, err = t.newRequest(ctx, task.opts, method, uploadURL, body); err != nil {
+		return nil, err
+	}
+	req.GetBody = newBody
+	req.ContentLength = n
+	return req, nil
+}
+
+func (t *HttpUploader) newRequest(ctx context.Context, opts *UploadOpts, method string, uploadURL string, body io.Reader) (req *http.Request, err error) {
+	if req, err = http.NewRequestWithContext(ctx, method, uploadURL, body); err != nil {
+		return nil, err
+	}
+	for k, v := range opts.Header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// send 添加认证信息后发送请求，状态码不在 expected 中时返回 HttpStatusError
+func (t *HttpUploader) send(req *http.Request, opts *UploadOpts, expected ...int) (resp *http.Response, err error) {
+	if resp, err = t.downloader.do(req, &DownloadOpts{FileURL: opts.URL, Credentials: opts.Credentials}); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(expected, resp.StatusCode) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &HttpStatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header}
+	}
+	return resp, nil
+}
+
+// body 返回文件 [offset, offset+n) 的内容，读取时受限速器控制并累加进度
+func (t *HttpUploader) body(ctx context.Context, task *uploadTask, offset int64, n int64) io.Reader {
+	limiters := []*RateLimiter{globalRateLimiter}
+	if t.Opts != nil && t.Opts.RateLimiter != nil {
+		limiters = append(limiters, t.Opts.RateLimiter)
+	}
+	r := &uploadProgressReader{r: io.NewSectionReader(task.file, offset, n), task: task}
+	return &rateLimitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+// tusMetadata 生成 Upload-Metadata，值使用 base64 编码
+func tusMetadata(opts *UploadOpts) string {
+	metadata := map[string]string{"filename": filepath.Base(opts.InputFilename)}
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// resolveLocation 将服务器返回的相对地址解析为绝对地址
+func resolveLocation(base string, location string) string {
+	if location == "" {
+		return ""
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return location
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	return u.ResolveReference(ref).String()
+}
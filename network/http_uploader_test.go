@@ -0,0 +1,266 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// refreshingCredential 第一次请求使用过期的令牌，服务器返回 401 后刷新
+type refreshingCredential struct {
+	token string
+}
+
+func (t *refreshingCredential) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return nil
+}
+
+func (t *refreshingCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	t.token = "fresh"
+	return true, nil
+}
+
+func writeUploadFile(t *testing.T, size int) (filename string, content []byte) {
+	content = make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	filename = filepath.Join(t.TempDir(), "upload.bin")
+	assert.Equal(t, nil, os.WriteFile(filename, content, 0644))
+	return filename, content
+}
+
+func TestHttpUploadMultipart(t *testing.T) {
+	filename, content := writeUploadFile(t, 300*1024)
+	var received []byte
+	var fields string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, header, err := r.FormFile("attachment")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		received, _ = io.ReadAll(f)
+		fields = r.FormValue("name") + "/" + header.Filename
+		w.Header().Set("Location", "/files/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	uploader, err := NewHttpUploader(&HttpDownloaderOpts{ProgressInterval: time.Millisecond})
+	assert.Equal(t, nil, err)
+	var last Progress
+	location, err := uploader.Upload(context.Background(), &UploadOpts{
+		URL:              server.URL + "/upload",
+		InputFilename:    filename,
+		FieldName:        "attachment",
+		Fields:           map[string]string{"name": "demo"},
+		Credentials:      &refreshingCredential{token: "expired"},
+		ProgressReporter: ProgressReporterFunc(func(progress Progress) { last = progress }),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, server.URL+"/files/1", location)
+	assert.Equal(t, content, received)
+	assert.Equal(t, "demo/upload.bin", fields)
+	assert.Equal(t, true, last.Done)
+	assert.Equal(t, int64(len(content)), last.BytesDone)
+	assert.Equal(t, SegmentStateDone, last.Segments[0].State)
+
+	_, err = uploader.Upload(context.Background(), &UploadOpts{URL: server.URL, InputFilename: filename, Method: "ftp"})
+	assert.ErrorIs(t, err, ErrUnknownUploadMethod)
+}
+
+func TestHttpUploadPut(t *testing.T) {
+	filename, content := writeUploadFile(t, 100*1024)
+	var lock sync.Mutex
+	received := make([]byte, len(content))
+	var ranges []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		contentRange := r.Header.Get("Content-Range")
+		start, total, ok := parseContentRange(contentRange)
+		if !ok || total != int64(len(content)) {
+			http.Error(w, "bad Content-Range", http.StatusBadRequest)
+			return
+		}
+		// 第二个分块第一次返回 503
+		if start > 0 && !failed {
+			failed = true
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		buf, _ := io.ReadAll(r.Body)
+		copy(received[start:], buf)
+		ranges = append(ranges, contentRange)
+		if start+int64(len(buf)) < total {
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		w.Header().Set("Location", "/files/put")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	uploader, err := NewHttpUploader(&HttpDownloaderOpts{RetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	assert.Equal(t, nil, err)
+	location, err := uploader.Upload(context.Background(), &UploadOpts{URL: server.URL + "/put", InputFilename: filename, Method: UploadMethodPut, ChunkSize: 40 * 1024})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, server.URL+"/files/put", location)
+	assert.Equal(t, content, received)
+	assert.Equal(t, []string{"bytes 0-40959/102400", "bytes 40960-81919/102400", "bytes 81920-102399/102400"}, ranges)
+}
+
+// tusServer 最小的 tus 服务器，第一次 PATCH 只收下一半数据就断开连接
+type tusServer struct {
+	lock     sync.Mutex
+	uploads  map[string][]byte
+	lengths  map[string]int64
+	metadata string
+	dropped  bool
+	// conflicts 接下来的这么多个 PATCH 返回 409
+	conflicts int
+}
+
+func (t *tusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodPost {
+		length, _ := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		id := fmt.Sprintf("/files/%d", len(t.uploads)+1)
+		t.uploads[id], t.lengths[id] = nil, length
+		t.metadata = r.Header.Get("Upload-Metadata")
+		w.Header().Set("Location", id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	data, ok := t.uploads[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(data)))
+		w.Header().Set("Upload-Length", strconv.FormatInt(t.lengths[r.URL.Path], 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		if r.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) || t.conflicts > 0 {
+			t.conflicts--
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if !t.dropped {
+			t.dropped = true
+			buf := make([]byte, r.ContentLength/2)
+			n, _ := io.ReadFull(r.Body, buf)
+			t.uploads[r.URL.Path] = append(data, buf[:n]...)
+			panic(http.ErrAbortHandler)
+		}
+		buf, _ := io.ReadAll(r.Body)
+		t.uploads[r.URL.Path] = append(data, buf...)
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(t.uploads[r.URL.Path])))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestHttpUploadTus(t *testing.T) {
+	filename, content := writeUploadFile(t, 100*1024)
+	tus := &tusServer{uploads: make(map[string][]byte), lengths: make(map[string]int64)}
+	server := httptest.NewServer(tus)
+	defer server.Close()
+
+	var retries []RetryAttempt
+	uploader, err := NewHttpUploader(&HttpDownloaderOpts{RetryPolicy: &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		OnRetry:        func(attempt RetryAttempt) { retries = append(retries, attempt) },
+	}})
+	assert.Equal(t, nil, err)
+	location, err := uploader.Upload(context.Background(), &UploadOpts{
+		URL:           server.URL + "/files/",
+		InputFilename: filename,
+		Method:        UploadMethodTus,
+		ChunkSize:     32 * 1024,
+		Metadata:      map[string]string{"type": "bin"},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, server.URL+"/files/1", location)
+	assert.Equal(t, content, tus.uploads["/files/1"])
+	assert.Equal(t, "filename "+base64.StdEncoding.EncodeToString([]byte("upload.bin"))+",type "+base64.StdEncoding.EncodeToString([]byte("bin")), tus.metadata)
+	assert.Equal(t, 1, len(retries))
+
+	// 继续一个已经上传了一部分的上传，只发送剩下的数据
+	tus.uploads["/files/2"], tus.lengths["/files/2"] = bytes.Clone(content[:50*1024]), int64(len(content))
+	var first Progress
+	location, err = uploader.Upload(context.Background(), &UploadOpts{
+		URL:              server.URL + "/files/",
+		UploadURL:        server.URL + "/files/2",
+		InputFilename:    filename,
+		Method:           UploadMethodTus,
+		ProgressReporter: ProgressReporterFunc(func(progress Progress) { first = progress }),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, server.URL+"/files/2", location)
+	assert.Equal(t, content, tus.uploads["/files/2"])
+	assert.Equal(t, int64(50*1024), first.ResumedOffset)
+
+	// 409 时查询偏移后继续上传，不占用重试次数
+	retries = nil
+	tus.conflicts = 1
+	location, err = uploader.Upload(context.Background(), &UploadOpts{URL: server.URL + "/files/", InputFilename: filename, Method: UploadMethodTus})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, tus.uploads[strings.TrimPrefix(location, server.URL)])
+	assert.Equal(t, 0, len(retries))
+
+	// 一直返回 409 时不会无限重试
+	tus.conflicts = 100
+	_, err = uploader.Upload(context.Background(), &UploadOpts{URL: server.URL + "/files/", InputFilename: filename, Method: UploadMethodTus})
+	assert.Equal(t, true, errors.Is(err, ErrUploadOffsetMismatch))
+	assert.Equal(t, 100-maxTusConflicts-1, tus.conflicts)
+	assert.Equal(t, 0, len(retries))
+	tus.conflicts = 0
+
+	// 文件大小与上传不一致时不会重试
+	tus.uploads["/files/9"], tus.lengths["/files/9"] = nil, int64(len(content))+1
+	_, err = uploader.Upload(context.Background(), &UploadOpts{UploadURL: server.URL + "/files/9", InputFilename: filename, Method: UploadMethodTus})
+	assert.Equal(t, true, errors.Is(err, ErrUploadLengthMismatch))
+	assert.Equal(t, 0, len(retries))
+
+	_, err = uploader.Upload(context.Background(), &UploadOpts{UploadURL: server.URL + "/files/404", InputFilename: filename, Method: UploadMethodTus})
+	assert.Equal(t, true, strings.Contains(err.Error(), "404"))
+}
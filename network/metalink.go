@@ -0,0 +1,191 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrMetalinkFileNotFound metalink document does not describe the requested file
+// ErrMetalinkFileNotFound metalink 文档中没有要查找的文件
+var ErrMetalinkFileNotFound = errors.New("metalink file not found")
+
+// metalinkHashAlgorithms metalink 中 IANA 哈希名称与校验和算法的对应关系，按照强度从高到低排列
+var metalinkHashAlgorithms = []struct {
+	name      string
+	algorithm string
+}{
+	{"sha-512", "sha512"},
+	{"sha-256", "sha256"},
+	{"sha-1", "sha1"},
+	{"md5", "md5"},
+}
+
+// Metalink Metalink v4 (RFC 5854) document
+// Metalink Metalink v4 (RFC 5854) 文档
+type Metalink struct {
+	XMLName xml.Name       `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Files   []MetalinkFile `xml:"file"`
+}
+
+// MetalinkFile file described by metalink, with its mirrors, hashes and signature
+// MetalinkFile metalink 描述的文件，包括镜像地址、哈希和签名
+type MetalinkFile struct {
+	Name      string             `xml:"name,attr"`
+	Size      int64              `xml:"size"`
+	Hashes    []MetalinkHash     `xml:"hash"`
+	URLs      []MetalinkURL      `xml:"url"`
+	Signature *MetalinkSignature `xml:"signature"`
+}
+
+// MetalinkHash hash of the whole file, Type is IANA hash name such as sha-256
+// MetalinkHash 整个文件的哈希，Type 为 sha-256 等 IANA 哈希名称
+type MetalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MetalinkURL mirror of the file, smaller Priority is preferred and 0 means no priority
+// MetalinkURL 文件的镜像地址，Priority 越小越优先，0 表示没有指定优先级
+type MetalinkURL struct {
+	Location string `xml:"location,attr"`
+	Priority int    `xml:"priority,attr"`
+	URL      string `xml:",chardata"`
+}
+
+// MetalinkSignature detached signature of the file
+// MetalinkSignature 文件的分离签名
+type MetalinkSignature struct {
+	MediaType string `xml:"mediatype,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// ParseMetalink parse Metalink v4 document, at most maxDocumentSize bytes are read
+// ParseMetalink 解析 Metalink v4 文档，最多读取 maxDocumentSize 字节
+func ParseMetalink(r io.Reader) (m *Metalink, err error) {
+	m = new(Metalink)
+	if err = xml.NewDecoder(io.LimitReader(r, maxDocumentSize)).Decode(m); err != nil {
+		return nil, fmt.Errorf("parse metalink: %w", err)
+	}
+	return m, nil
+}
+
+// File return file named name, or the only file when name is empty
+// File 返回名为 name 的文件，name 为空并且文档中只有一个文件时返回这个文件
+func (t *Metalink) File(name string) (file *MetalinkFile, err error) {
+	if name == "" && len(t.Files) == 1 {
+		return &t.Files[0], nil
+	}
+	for i := range t.Files {
+		if t.Files[i].Name == name {
+			return &t.Files[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMetalinkFileNotFound, name)
+}
+
+// Checksum return the strongest supported hash in the algorithm:digest form accepted by DownloadOpts.Checksum
+// Checksum 返回支持的最强哈希，格式为 DownloadOpts.Checksum 使用的 算法:摘要
+func (t *MetalinkFile) Checksum() (checksum string, err error) {
+	for _, algorithm := range metalinkHashAlgorithms {
+		for _, hash := range t.Hashes {
+			if strings.EqualFold(hash.Type, algorithm.name) {
+				return algorithm.algorithm + ":" + strings.TrimSpace(hash.Value), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: metalink file %s has no supported hash", ErrUnknownChecksumType, t.Name)
+}
+
+// Mirrors return URLs sorted by priority, URLs in preferredLocations (ISO 3166-1 country codes) come first in the given order
+// Mirrors 返回按照优先级排序的地址，位于 preferredLocations（ISO 3166-1 国家代码）中的地址按照给定顺序排在最前面
+func (t *MetalinkFile) Mirrors(preferredLocations ...string) (urls []string) {
+	locationRank := func(location string) int {
+		for i, preferred := range preferredLocations {
+			if strings.EqualFold(location, preferred) {
+				return i
+			}
+		}
+		return len(preferredLocations)
+	}
+	priority := func(u MetalinkURL) int {
+		if u.Priority <= 0 {
+			return int(^uint(0) >> 1)
+		}
+		return u.Priority
+	}
+
+	sorted := append([]MetalinkURL(nil), t.URLs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if ri, rj := locationRank(sorted[i].Location), locationRank(sorted[j].Location); ri != rj {
+			return ri < rj
+		}
+		return priority(sorted[i]) < priority(sorted[j])
+	})
+	for _, u := range sorted {
+		if u := strings.TrimSpace(u.URL); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// DownloadOpts fill FileURL, Mirrors and Checksum of a copy of opts from metalink file
+// DownloadOpts 复制 opts 并根据 metalink 文件填充 FileURL、Mirrors 和 Checksum
+func (t *MetalinkFile) DownloadOpts(opts DownloadOpts, preferredLocations ...string) (*DownloadOpts, error) {
+	urls := t.Mirrors(preferredLocations...)
+	if len(urls) < 1 {
+		return nil, fmt.Errorf("metalink file %s has no url", t.Name)
+	}
+	checksum, err := t.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	opts.FileURL, opts.Mirrors, opts.Checksum = urls[0], urls[1:], checksum
+	return &opts, nil
+}
+
+// DownloadMetalinkFile download file described by metalink over its mirrors and verify it with the strongest listed hash and the listed size.
+// If verifier is not nil and metalink carries a signature, the downloaded file is verified too. The file is removed when size or signature mismatches
+// DownloadMetalinkFile 通过 metalink 中的镜像下载文件，并使用其中最强的哈希和文件大小校验。
+// verifier 不为空并且 metalink 中带有签名时同时校验签名，文件大小或者签名不匹配时删除下载的文件
+func (t *HttpDownloader) DownloadMetalinkFile(ctx context.Context, file *MetalinkFile, opts DownloadOpts, verifier SignatureVerifier) (err error) {
+	downloadOpts, err := file.DownloadOpts(opts)
+	if err != nil {
+		return err
+	}
+	if err = t.Download(ctx, downloadOpts); err != nil {
+		return err
+	}
+	// 解压或者解包时没有与 Size 对应的文件
+	if file.Size > 0 && downloadOpts.ExtractDir == "" && detectCompression(downloadOpts.Decompress, downloadOpts.FileURL) == CompressionNone {
+		info, err := os.Stat(downloadOpts.OutputFilename)
+		if err != nil {
+			return err
+		}
+		if info.Size() != file.Size {
+			os.Remove(downloadOpts.OutputFilename)
+			return &LengthMismatchError{URL: downloadOpts.FileURL, Expected: file.Size, Actual: info.Size()}
+		}
+	}
+	if verifier == nil || file.Signature == nil {
+		return nil
+	}
+
+	f, err := os.Open(downloadOpts.OutputFilename)
+	if err != nil {
+		return err
+	}
+	err = verifier.Verify(f, bytes.TrimSpace([]byte(file.Signature.Value)))
+	f.Close()
+	if err != nil {
+		os.Remove(downloadOpts.OutputFilename)
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetalink(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	sum := sha256.Sum256(content)
+	mirror := newTestServer(t, content, nil)
+	defer mirror.Close()
+
+	document := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="app.tar.gz">
+    <size>` + "16384" + `</size>
+    <hash type="md5">` + strings.Repeat("00", 16) + `</hash>
+    <hash type="sha-256">` + hex.EncodeToString(sum[:]) + `</hash>
+    <url location="us">http://127.0.0.1:1/no-priority</url>
+    <url location="de" priority="2">http://127.0.0.1:1/de</url>
+    <url location="jp" priority="1">` + mirror.URL + `/file</url>
+  </file>
+</metalink>`
+	m, err := ParseMetalink(strings.NewReader(document))
+	assert.Equal(t, nil, err)
+
+	file, err := m.File("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(16384), file.Size)
+	checksum, err := file.Checksum()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), checksum)
+	assert.Equal(t, []string{mirror.URL + "/file", "http://127.0.0.1:1/de", "http://127.0.0.1:1/no-priority"}, file.Mirrors())
+	assert.Equal(t, []string{"http://127.0.0.1:1/de", mirror.URL + "/file", "http://127.0.0.1:1/no-priority"}, file.Mirrors("DE"))
+
+	_, err = m.File("missing")
+	assert.Equal(t, true, errors.Is(err, ErrMetalinkFileNotFound))
+
+	// 首选的镜像不可用时切换到其他镜像
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	output := filepath.Join(t.TempDir(), "app.tar.gz")
+	opts, err := file.DownloadOpts(DownloadOpts{OutputFilename: output}, "de")
+	assert.Equal(t, nil, err)
+	err = dl.Download(context.Background(), opts)
+	assert.Equal(t, nil, err)
+	buf, err := os.ReadFile(output)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, content, buf)
+
+	// 文件大小与 metalink 不一致时删除下载的文件
+	file.Size = 1000
+	err = dl.DownloadMetalinkFile(context.Background(), file, DownloadOpts{OutputFilename: output}, nil)
+	var lengthErr *LengthMismatchError
+	assert.Equal(t, true, errors.As(err, &lengthErr))
+	assert.Equal(t, int64(len(content)), lengthErr.Actual)
+	assert.Equal(t, false, fileExists(output))
+	file.Size = int64(len(content))
+
+	// 签名不匹配时删除下载的文件
+	file.Signature = &MetalinkSignature{Value: "bad"}
+	verifier := &Ed25519Verifier{}
+	err = dl.DownloadMetalinkFile(context.Background(), file, DownloadOpts{OutputFilename: output}, verifier)
+	assert.Equal(t, true, errors.Is(err, ErrSignatureMismatch))
+	assert.Equal(t, false, fileExists(output))
+}
@@ -0,0 +1,179 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval 默认的进度报告间隔
+const defaultProgressInterval = 500 * time.Millisecond
+
+// SegmentState state of a download segment
+// SegmentState 下载分段的状态
+type SegmentState = string
+
+const (
+	SegmentStatePending     SegmentState = "pending"
+	SegmentStateDownloading SegmentState = "downloading"
+	SegmentStateRetrying    SegmentState = "retrying"
+	SegmentStateDone        SegmentState = "done"
+	SegmentStateFailed      SegmentState = "failed"
+)
+
+// Progress snapshot of a download
+// Progress 下载进度快照
+type Progress struct {
+	// URL 下载地址
+	URL string
+	// OutputFilename 下载目标文件
+	OutputFilename string
+	// TotalSize 文件总大小，-1 表示未知
+	TotalSize int64
+	// BytesDone 已经下载的字节数，包括续传前已经下载的部分
+	BytesDone int64
+	// ResumedOffset 本次下载开始时已经存在的字节数
+	ResumedOffset int64
+	// Speed 最近一个报告间隔内的速度，字节每秒
+	Speed float64
+	// AverageSpeed 本次下载开始以来的平均速度，字节每秒，不包括续传前已经下载的部分
+	AverageSpeed float64
+	// ETA 预计剩余时间，-1 表示未知
+	ETA time.Duration
+	// Elapsed 本次下载已经用去的时间
+	Elapsed time.Duration
+	// Segments 每个分段的进度
+	Segments []SegmentProgress
+	// Done 下载是否已经结束，无论成功还是失败最后一次报告都为 true
+	Done bool
+}
+
+// SegmentProgress progress of a download segment
+// SegmentProgress 下载分段的进度
+type SegmentProgress struct {
+	// Start 分段起始偏移
+	Start int64
+	// End 分段结束偏移，闭区间，文件大小未知时为 -1
+	End int64
+	// Written 分段已经写入的字节数
+	Written int64
+	// State 分段状态
+	State SegmentState
+}
+
+// Percent return finished percent 0~100, -1 if total size is unknown
+// Percent 返回完成百分比 0~100，文件大小未知时返回 -1
+func (t *Progress) Percent() float64 {
+	if t.TotalSize < 0 {
+		return -1
+	}
+	if t.TotalSize == 0 {
+		return 100
+	}
+	return float64(t.BytesDone) * 100 / float64(t.TotalSize)
+}
+
+// ProgressReporter receive download progress, ReportProgress is called from the download goroutine so it should return quickly
+// ProgressReporter 接收下载进度，ReportProgress 在下载协程中调用，应当尽快返回
+type ProgressReporter interface {
+	ReportProgress(progress Progress)
+}
+
+// ProgressReporterFunc adapter to use ordinary function as ProgressReporter
+// ProgressReporterFunc 将普通函数适配为 ProgressReporter
+type ProgressReporterFunc func(progress Progress)
+
+func (t ProgressReporterFunc) ReportProgress(progress Progress) {
+	t(progress)
+}
+
+// progressTracker 根据断点续传清单计算下载进度并定时报告
+type progressTracker struct {
+	lock          sync.Mutex
+	task          *downloadTask
+	start         time.Time
+	resumedOffset int64
+	lastTime      time.Time
+	lastBytes     int64
+	speed         float64
+}
+
+func newProgressTracker(task *downloadTask) (t *progressTracker) {
+	t = &progressTracker{task: task, start: time.Now()}
+	t.reset()
+	return t
+}
+
+// reset 清单重建后以新的清单作为起点
+func (t *progressTracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.resumedOffset = 0
+	if m := t.task.manifest; m != nil {
+		t.resumedOffset, _ = m.progress()
+	}
+	t.lastTime = time.Now()
+	t.lastBytes = t.resumedOffset
+}
+
+// enabled 是否有人接收进度
+func (t *progressTracker) enabled() bool {
+	return t.task.opts.ProgressReporter != nil || t.task.opts.ProgressChan != nil
+}
+
+// report 计算当前进度并发送给 ProgressReporter 和 ProgressChan，ProgressChan 已满时丢弃本次进度而不是阻塞下载
+func (t *progressTracker) report(done bool) {
+	if !t.enabled() {
+		return
+	}
+
+	progress := t.snapshot(time.Now(), done)
+	if t.task.opts.ProgressReporter != nil {
+		t.task.opts.ProgressReporter.ReportProgress(progress)
+	}
+	if t.task.opts.ProgressChan != nil {
+		select {
+		case t.task.opts.ProgressChan <- progress:
+		default:
+		}
+	}
+}
+
+func (t *progressTracker) snapshot(now time.Time, done bool) (progress Progress) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	m := t.task.manifest
+	progress = Progress{
+		URL:            t.task.opts.FileURL,
+		OutputFilename: t.task.opts.OutputFilename,
+		TotalSize:      -1,
+		ResumedOffset:  t.resumedOffset,
+		ETA:            -1,
+		Elapsed:        now.Sub(t.start),
+		Done:           done,
+	}
+	if m == nil {
+		return
+	}
+	progress.BytesDone, progress.Segments = m.progress()
+	progress.TotalSize = m.totalSize()
+
+	if elapsed := now.Sub(t.lastTime).Seconds(); elapsed > 0 {
+		t.speed = float64(progress.BytesDone-t.lastBytes) / elapsed
+		t.lastTime = now
+		t.lastBytes = progress.BytesDone
+	}
+	progress.Speed = t.speed
+	if elapsed := progress.Elapsed.Seconds(); elapsed > 0 {
+		progress.AverageSpeed = float64(progress.BytesDone-t.resumedOffset) / elapsed
+	}
+
+	if progress.TotalSize >= 0 {
+		if remaining := progress.TotalSize - progress.BytesDone; remaining <= 0 {
+			progress.ETA = 0
+		} else if progress.Speed > 0 {
+			progress.ETA = time.Duration(float64(remaining) / progress.Speed * float64(time.Second))
+		}
+	}
+	return
+}
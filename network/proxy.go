@@ -0,0 +1,221 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyConfig 代理配置，请求地址匹配 noProxy 时直接连接
+type proxyConfig struct {
+	httpProxy  *url.URL
+	httpsProxy *url.URL
+	noProxy    *noProxyMatcher
+	// skipLoopback 与 http.ProxyFromEnvironment 一致，环境变量中的代理不用于本机地址
+	skipLoopback bool
+}
+
+// newProxyFunc 根据选项生成 http.Transport 的 Proxy 函数，ProxyAddr 为空时使用环境变量中的代理，都没有时返回 nil
+func newProxyFunc(opts *HttpDownloaderOpts) (proxy func(*http.Request) (*url.URL, error), err error) {
+	config := new(proxyConfig)
+	noProxy := opts.NoProxy
+	if opts.ProxyAddr != "" {
+		if config.httpProxy, err = parseProxyAddr(opts.ProxyAddr); err != nil {
+			return nil, err
+		}
+		config.httpsProxy = config.httpProxy
+	} else if !opts.IgnoreEnvironmentProxy {
+		allProxy := getenv("ALL_PROXY", "all_proxy")
+		if config.httpProxy, err = parseProxyAddr(firstNonEmpty(getenv("HTTP_PROXY", "http_proxy"), allProxy)); err != nil {
+			return nil, err
+		}
+		if config.httpsProxy, err = parseProxyAddr(firstNonEmpty(getenv("HTTPS_PROXY", "https_proxy"), allProxy)); err != nil {
+			return nil, err
+		}
+		noProxy = append(strings.Split(getenv("NO_PROXY", "no_proxy"), ","), noProxy...)
+		config.skipLoopback = true
+	}
+
+	if config.httpProxy == nil && config.httpsProxy == nil {
+		return nil, nil
+	}
+	config.noProxy = newNoProxyMatcher(noProxy)
+	return config.proxyFor, nil
+}
+
+func (t *proxyConfig) proxyFor(req *http.Request) (*url.URL, error) {
+	proxyURL := t.httpProxy
+	if req.URL.Scheme == "https" {
+		proxyURL = t.httpsProxy
+	}
+	if proxyURL == nil {
+		return nil, nil
+	}
+
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if t.skipLoopback && isLoopback(host) {
+		return nil, nil
+	}
+	if t.noProxy.match(host, port) {
+		return nil, nil
+	}
+	return proxyURL, nil
+}
+
+// parseProxyAddr 解析代理地址，支持 http、https、socks5 和 socks5h，没有协议时当作 http 代理。
+// 错误信息中的代理密码会被隐藏
+func parseProxyAddr(addr string) (proxyURL *url.URL, err error) {
+	if addr == "" {
+		return nil, nil
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	proxyURL, err = url.Parse(addr)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %s", redactProxyAddr(addr))
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxyURL, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q of %s", proxyURL.Scheme, proxyURL.Redacted())
+	}
+}
+
+// redactProxyAddr 隐藏无法解析的代理地址中 @ 之前的用户名和密码
+func redactProxyAddr(addr string) string {
+	scheme, rest, found := strings.Cut(addr, "://")
+	if !found {
+		scheme, rest = "", addr
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = "xxxxx" + rest[i:]
+	}
+	if scheme == "" {
+		return rest
+	}
+	return scheme + "://" + rest
+}
+
+// noProxyMatcher NO_PROXY 规则，支持 *、IP、CIDR、域名（同时匹配子域名）、.域名 和 *.域名（只匹配子域名），都可以带端口
+type noProxyMatcher struct {
+	all     bool
+	ips     []noProxyIP
+	cidrs   []*net.IPNet
+	domains []noProxyDomain
+}
+
+type noProxyIP struct {
+	ip   net.IP
+	port string
+}
+
+type noProxyDomain struct {
+	domain string
+	// subdomainOnly 以 . 或 *. 开头的规则不匹配域名本身
+	subdomainOnly bool
+	port          string
+}
+
+func newNoProxyMatcher(rules []string) (t *noProxyMatcher) {
+	t = new(noProxyMatcher)
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if rule == "*" {
+			t.all = true
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(rule); err == nil {
+			t.cidrs = append(t.cidrs, cidr)
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(rule)
+		if err != nil {
+			host, port = strings.Trim(rule, "[]"), ""
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			t.ips = append(t.ips, noProxyIP{ip: ip, port: port})
+			continue
+		}
+
+		domain := noProxyDomain{domain: host, port: port}
+		if strings.HasPrefix(host, "*.") || strings.HasPrefix(host, ".") {
+			domain.domain = strings.TrimPrefix(strings.TrimPrefix(host, "*"), ".")
+			domain.subdomainOnly = true
+		}
+		t.domains = append(t.domains, domain)
+	}
+	return t
+}
+
+// match 检查请求的主机和端口是否需要绕过代理
+func (t *noProxyMatcher) match(host string, port string) bool {
+	if t.all {
+		return true
+	}
+	host = strings.ToLower(host)
+	if ip := net.ParseIP(host); ip != nil {
+		for _, cidr := range t.cidrs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		for _, rule := range t.ips {
+			if rule.ip.Equal(ip) && (rule.port == "" || rule.port == port) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, rule := range t.domains {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, "."+rule.domain) || (!rule.subdomainOnly && host == rule.domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// getenv 返回第一个不为空的环境变量
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// globalRateLimiter 进程内所有下载器共享的限速器，默认不限速
+var globalRateLimiter = NewRateLimiter(0, 0)
+
+// GlobalRateLimiter return the rate limiter shared by all downloaders in the process, it is unlimited until SetLimit is called
+// GlobalRateLimiter 返回进程内所有下载器共享的限速器，调用 SetLimit 之前不限速
+func GlobalRateLimiter() *RateLimiter {
+	return globalRateLimiter
+}
+
+// NewRateLimiter create token bucket rate limiter, bytesPerSecond <= 0 means unlimited, burst defaults to one second of bytesPerSecond
+// NewRateLimiter 新建令牌桶限速器，bytesPerSecond 小于等于0表示不限速，burst 默认为一秒的流量
+func NewRateLimiter(bytesPerSecond int64, burst int64) (t *RateLimiter) {
+	t = new(RateLimiter)
+	t.SetLimit(bytesPerSecond, burst)
+	return t
+}
+
+// RateLimiter token bucket rate limiter in bytes, safe for concurrent use and adjustable at runtime
+// RateLimiter 以字节为单位的令牌桶限速器，线程安全，可以在运行时调整速率
+type RateLimiter struct {
+	lock sync.Mutex
+	// limit 每秒产生的令牌数，小于等于0表示不限速
+	limit float64
+	// burst 令牌桶容量，也是单次读取的最大字节数
+	burst float64
+	// tokens 当前令牌数，预支之后可能为负数
+	tokens float64
+	// last 上一次计算令牌的时间
+	last time.Time
+}
+
+// SetLimit update rate limit, transfers in progress use the new limit from their next read
+// SetLimit 更新速率，正在进行的传输从下一次读取开始使用新的速率
+func (t *RateLimiter) SetLimit(bytesPerSecond int64, burst int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.advance(time.Now())
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	if t.limit <= 0 {
+		// 从不限速切换为限速时令牌桶是满的
+		t.tokens = float64(burst)
+	}
+	t.limit = float64(bytesPerSecond)
+	t.burst = float64(burst)
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
+
+// Limit return current bytes per second and burst
+// Limit 返回当前的每秒字节数和令牌桶容量
+func (t *RateLimiter) Limit() (bytesPerSecond int64, burst int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return int64(t.limit), int64(t.burst)
+}
+
+// WaitN block until n bytes are allowed to pass or ctx is done
+// WaitN 阻塞直到允许通过 n 个字节或者 ctx 结束
+func (t *RateLimiter) WaitN(ctx context.Context, n int) (err error) {
+	t.lock.Lock()
+	if t.limit <= 0 {
+		t.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	t.advance(now)
+	t.tokens -= float64(n)
+	wait := time.Duration(-t.tokens / t.limit * float64(time.Second))
+	t.lock.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// 没有用掉的令牌归还给令牌桶
+		t.lock.Lock()
+		t.tokens += float64(n)
+		t.lock.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// chunkSize 单次读取的最大字节数，不限速时返回 0
+func (t *RateLimiter) chunkSize() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.limit <= 0 {
+		return 0
+	}
+	if t.burst < 1 {
+		return 1
+	}
+	return int(t.burst)
+}
+
+// advance 按照流逝的时间补充令牌
+func (t *RateLimiter) advance(now time.Time) {
+	if !t.last.IsZero() && t.limit > 0 {
+		t.tokens += now.Sub(t.last).Seconds() * t.limit
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+	}
+	t.last = now
+}
+
+// rateLimitedReader 每次读取后等待所有限速器放行
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*RateLimiter
+}
+
+func (t *rateLimitedReader) Read(p []byte) (n int, err error) {
+	for _, limiter := range t.limiters {
+		if size := limiter.chunkSize(); size > 0 && len(p) > size {
+			p = p[:size]
+		}
+	}
+
+	n, err = t.r.Read(p)
+	for _, limiter := range t.limiters {
+		if waitErr := limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(100*1024, 10*1024)
+	ctx := context.Background()
+
+	// 令牌桶初始是满的，第一次读取不需要等待
+	start := time.Now()
+	assert.Equal(t, nil, limiter.WaitN(ctx, 10*1024))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, nil, limiter.WaitN(ctx, 10*1024))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// 运行时调整为不限速
+	limiter.SetLimit(0, 0)
+	start = time.Now()
+	assert.Equal(t, nil, limiter.WaitN(ctx, 1024*1024))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	limiter.SetLimit(1024, 1024)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, nil, limiter.WaitN(ctx, 1024))
+	assert.Equal(t, context.DeadlineExceeded, limiter.WaitN(ctx, 1024))
+}
@@ -0,0 +1,381 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/hakur/util"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// ErrPlatformNotFound image index has no manifest for the requested platform
+// ErrPlatformNotFound 镜像索引中没有请求的平台对应的清单
+var ErrPlatformNotFound = errors.New("platform not found in image index")
+
+// OCIPlatform platform of an image manifest
+// OCIPlatform 镜像清单的平台
+type OCIPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	OSVersion    string `json:"os.version,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCIDescriptor reference to a manifest or blob by digest
+// OCIDescriptor 通过摘要引用清单或者 blob
+type OCIDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *OCIPlatform      `json:"platform,omitempty"`
+}
+
+// OCIManifest Docker v2 / OCI image manifest, or Docker manifest list / OCI image index when Manifests is not empty
+// OCIManifest Docker v2 / OCI 镜像清单，Manifests 不为空时为 Docker manifest list / OCI 镜像索引
+type OCIManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        OCIDescriptor     `json:"config"`
+	Layers        []OCIDescriptor   `json:"layers"`
+	Manifests     []OCIDescriptor   `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// IsIndex return true if manifest is a Docker manifest list or OCI image index
+// IsIndex 清单是否为 Docker manifest list 或 OCI 镜像索引
+func (t *OCIManifest) IsIndex() bool {
+	return t.MediaType == MediaTypeDockerManifestList || t.MediaType == MediaTypeOCIIndex || len(t.Manifests) > 0
+}
+
+// RegistryClientOpts options of RegistryClient
+// RegistryClientOpts RegistryClient 的选项
+type RegistryClientOpts struct {
+	// Username Password registry account, empty means anonymous pulling
+	// Username Password 镜像仓库账号，为空时匿名拉取
+	Username string
+	Password string
+	// Platform os/arch[/variant] selected from image index, default is linux/GOARCH
+	// Platform 从镜像索引中选择的平台，格式为 os/arch[/variant]，默认 linux/GOARCH
+	Platform string
+}
+
+// RegistryClient pull manifests and blobs from Docker registry v2 / OCI distribution API without docker daemon, blobs are downloaded by HttpDownloader and verified by digest
+// RegistryClient 不依赖 docker daemon 从 Docker registry v2 / OCI distribution API 拉取清单和 blob，blob 通过 HttpDownloader 下载并按照摘要校验
+type RegistryClient struct {
+	Downloader *HttpDownloader
+	Opts       *RegistryClientOpts
+
+	lock        sync.Mutex
+	credentials map[string]*registryCredential
+}
+
+// NewRegistryClient create registry client using downloader for all requests
+// NewRegistryClient 新建镜像仓库客户端，所有请求都通过 downloader 发送
+func NewRegistryClient(downloader *HttpDownloader, opts *RegistryClientOpts) (t *RegistryClient) {
+	t = new(RegistryClient)
+	t.Downloader = downloader
+	t.Opts = opts
+	t.credentials = make(map[string]*registryCredential)
+	return t
+}
+
+// registryRepository 仓库 API 地址、镜像路径和引用，docker.io 的 API 地址为 registry-1.docker.io。
+// ParseDockerImageNameInfo 解析摘要引用时 Path 会带有 @sha256 后缀，Digest 会带有 @ 前缀，这里统一去掉
+func registryRepository(image *util.DockerImageNameInfo) (baseURL string, repository string, reference string) {
+	schema := image.Schema
+	if schema == "" {
+		schema = "https"
+	}
+	domain := image.Domain
+	if domain == "docker.io" || domain == "index.docker.io" {
+		domain = "registry-1.docker.io"
+	}
+	repository, _, _ = strings.Cut(image.Path, "@")
+	reference = strings.TrimPrefix(image.GetReference(), "@")
+	return schema + "://" + domain + "/v2/" + repository, repository, reference
+}
+
+// credential 每个镜像仓库共用一个认证信息，token 的 scope 为该仓库的 pull 权限，认证信息只会发送给镜像仓库所在的主机
+func (t *RegistryClient) credential(image *util.DockerImageNameInfo) *registryCredential {
+	baseURL, repository, _ := registryRepository(image)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	credential := t.credentials[baseURL]
+	if credential == nil {
+		u, _ := url.Parse(baseURL)
+		credential = &registryCredential{client: t, host: u.Host, scope: "repository:" + repository + ":pull"}
+		t.credentials[baseURL] = credential
+	}
+	return credential
+}
+
+// FetchManifest fetch manifest or index by tag or digest, digest is the sha256 of raw manifest and is verified when reference is a digest
+// FetchManifest 按照 tag 或摘要获取清单或索引，digest 为原始清单的 sha256，按照摘要获取时会校验摘要
+func (t *RegistryClient) FetchManifest(ctx context.Context, image *util.DockerImageNameInfo, reference string) (manifest *OCIManifest, raw []byte, digest string, err error) {
+	baseURL, _, _ := registryRepository(image)
+	opts := &DownloadOpts{
+		FileURL:     baseURL + "/manifests/" + reference,
+		Header:      http.Header{"Accept": {MediaTypeOCIIndex, MediaTypeOCIManifest, MediaTypeDockerManifestList, MediaTypeDockerManifest}},
+		Credentials: t.credential(image),
+	}
+	if strings.Contains(reference, ":") {
+		opts.Checksum = reference
+	}
+	if raw, err = t.Downloader.fetch(ctx, opts); err != nil {
+		return nil, nil, "", err
+	}
+
+	manifest = new(OCIManifest)
+	if err = json.Unmarshal(raw, manifest); err != nil {
+		return nil, nil, "", fmt.Errorf("parse manifest %s: %w", reference, err)
+	}
+	digest = fmt.Sprintf("sha256:%x", sha256.Sum256(raw))
+	return manifest, raw, digest, nil
+}
+
+// ResolveManifest fetch image manifest, select manifest of Opts.Platform when reference points to an index
+// ResolveManifest 获取镜像清单，引用指向索引时选择 Opts.Platform 对应的清单
+func (t *RegistryClient) ResolveManifest(ctx context.Context, image *util.DockerImageNameInfo) (manifest *OCIManifest, raw []byte, digest string, err error) {
+	_, _, reference := registryRepository(image)
+	if manifest, raw, digest, err = t.FetchManifest(ctx, image, reference); err != nil || !manifest.IsIndex() {
+		return
+	}
+
+	desc, err := selectPlatform(manifest.Manifests, t.platform())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return t.FetchManifest(ctx, image, desc.Digest)
+}
+
+func (t *RegistryClient) platform() string {
+	if t.Opts != nil && t.Opts.Platform != "" {
+		return t.Opts.Platform
+	}
+	return "linux/" + runtime.GOARCH
+}
+
+// selectPlatform 选择 os/arch[/variant] 对应的清单，没有指定 variant 时使用第一个 os 和 arch 都匹配的清单
+func selectPlatform(manifests []OCIDescriptor, platform string) (desc *OCIDescriptor, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid platform %q", platform)
+	}
+	for i := range manifests {
+		p := manifests[i].Platform
+		if p == nil || p.OS != parts[0] || p.Architecture != parts[1] {
+			continue
+		}
+		if len(parts) > 2 && p.Variant != parts[2] {
+			continue
+		}
+		return &manifests[i], nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrPlatformNotFound, platform)
+}
+
+// DownloadBlob download blob into outputFilename with resuming, retrying and digest verification of HttpDownloader, URLs of foreign layers are used as mirrors
+// DownloadBlob 下载 blob 到 outputFilename，使用 HttpDownloader 的断点续传、重试和摘要校验，外部层的 URLs 作为镜像地址
+func (t *RegistryClient) DownloadBlob(ctx context.Context, image *util.DockerImageNameInfo, desc OCIDescriptor, outputFilename string) (err error) {
+	baseURL, _, _ := registryRepository(image)
+	return t.Downloader.Download(ctx, &DownloadOpts{
+		FileURL:        baseURL + "/blobs/" + desc.Digest,
+		Mirrors:        desc.URLs,
+		OutputFilename: outputFilename,
+		Checksum:       desc.Digest,
+		Atomic:         true,
+		Credentials:    t.credential(image),
+	})
+}
+
+// Pull save image of Opts.Platform into dir in OCI image layout, blobs already in dir are verified and not downloaded again
+// Pull 将 Opts.Platform 对应的镜像以 OCI image layout 格式保存到 dir 中，dir 中已存在的 blob 校验通过后不会重复下载
+func (t *RegistryClient) Pull(ctx context.Context, image *util.DockerImageNameInfo, dir string) (manifest *OCIManifest, err error) {
+	manifest, raw, digest, err := t.ResolveManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err = os.MkdirAll(blobDir, 0755); err != nil {
+		return nil, err
+	}
+	blobFilename := func(digest string) string {
+		return filepath.Join(blobDir, strings.TrimPrefix(digest, "sha256:"))
+	}
+
+	for _, desc := range append([]OCIDescriptor{manifest.Config}, manifest.Layers...) {
+		if err = t.DownloadBlob(ctx, image, desc, blobFilename(desc.Digest)); err != nil {
+			return nil, err
+		}
+	}
+	if err = os.WriteFile(blobFilename(digest), raw, 0644); err != nil {
+		return nil, err
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeOCIManifest
+	}
+	desc := OCIDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(raw))}
+	if image.Tag != "" {
+		desc.Annotations = map[string]string{"org.opencontainers.image.ref.name": image.Tag}
+	}
+	index, err := json.Marshal(&OCIManifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []OCIDescriptor{desc}})
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
+
+// registryCredential 处理镜像仓库的 401 认证质询，Bearer 质询从 realm 获取 token，Basic 质询使用账号密码
+type registryCredential struct {
+	client *RegistryClient
+	// host 镜像仓库的主机，清单中 urls 指向的其他主机不会收到认证信息
+	host          string
+	scope         string
+	lock          sync.Mutex
+	authorization string
+}
+
+func (t *registryCredential) Authorize(req *http.Request) error {
+	if req.URL.Host != t.host {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.authorization != "" {
+		req.Header.Set("Authorization", t.authorization)
+	}
+	return nil
+}
+
+func (t *registryCredential) Refresh(ctx context.Context, resp *http.Response) (retry bool, err error) {
+	if resp.Request != nil && resp.Request.URL.Host != t.host {
+		return false, nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// 其他请求已经刷新过认证信息了，直接使用新的认证信息重试
+	if resp.Request != nil && resp.Request.Header.Get("Authorization") != t.authorization {
+		return true, nil
+	}
+
+	var username, password string
+	if opts := t.client.Opts; opts != nil {
+		username, password = opts.Username, opts.Password
+	}
+
+	scheme, params := parseAuthChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch scheme {
+	case "bearer":
+		token, err := t.fetchToken(ctx, params, username, password)
+		if err != nil {
+			return false, err
+		}
+		t.authorization = "Bearer " + token
+		return true, nil
+	case "basic":
+		if username == "" {
+			return false, nil
+		}
+		t.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		return true, nil
+	}
+	return false, nil
+}
+
+// fetchToken 按照 docker token 认证规范从 realm 获取 token
+func (t *registryCredential) fetchToken(ctx context.Context, params map[string]string, username string, password string) (token string, err error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = t.scope
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	// 不把下载器本身的认证信息发送给认证服务器
+	opts := &DownloadOpts{FileURL: realm.String(), Credentials: CredentialFunc(func(req *http.Request) error { return nil })}
+	if username != "" {
+		opts.Credentials = &BasicAuthCredential{Username: username, Password: password}
+	}
+	buf, err := t.client.Downloader.fetch(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.Unmarshal(buf, &resp); err != nil {
+		return "", fmt.Errorf("parse token response: %w", err)
+	}
+	if resp.Token == "" {
+		resp.Token = resp.AccessToken
+	}
+	if resp.Token == "" {
+		return "", errors.New("token response has no token")
+	}
+	return resp.Token, nil
+}
+
+// parseAuthChallenge 解析 WWW-Authenticate 头，返回小写的认证方式和参数，参数值可以带引号，引号中可以有逗号
+func parseAuthChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	scheme = strings.ToLower(scheme)
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(strings.TrimSpace(rest), ",") {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,167 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hakur/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRegistry 测试用镜像仓库，需要通过 token 认证才能访问
+type testRegistry struct {
+	*httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	tokens    int
+}
+
+func digestOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func newTestRegistry(t *testing.T) (r *testRegistry) {
+	r = &testRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			user, pass, _ := req.BasicAuth()
+			if user != "user" || pass != "pass" || req.URL.Query().Get("scope") != "repository:library/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			r.tokens++
+			w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/app:pull"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if reference, ok := strings.CutPrefix(req.URL.Path, "/v2/library/app/manifests/"); ok {
+			manifest, ok := r.manifests[reference]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(manifest)
+			return
+		}
+		if digest, ok := strings.CutPrefix(req.URL.Path, "/v2/library/app/blobs/"); ok {
+			blob, ok := r.blobs[digest]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			http.ServeContent(w, req, "blob", time.Time{}, bytes.NewReader(blob))
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	return r
+}
+
+func (r *testRegistry) addBlob(content []byte, mediaType string) OCIDescriptor {
+	digest := digestOf(content)
+	r.blobs[digest] = content
+	return OCIDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+func (r *testRegistry) addManifest(t *testing.T, manifest *OCIManifest, tag string) OCIDescriptor {
+	raw, err := json.Marshal(manifest)
+	assert.Equal(t, nil, err)
+	digest := digestOf(raw)
+	r.manifests[digest] = raw
+	if tag != "" {
+		r.manifests[tag] = raw
+	}
+	return OCIDescriptor{MediaType: manifest.MediaType, Digest: digest, Size: int64(len(raw))}
+}
+
+func TestRegistryClientPull(t *testing.T) {
+	registry := newTestRegistry(t)
+	defer registry.Close()
+
+	layers := map[string][]byte{"amd64": bytes.Repeat([]byte("amd64"), 1024), "arm64": bytes.Repeat([]byte("arm64"), 1024)}
+	var manifests []OCIDescriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		desc := registry.addManifest(t, &OCIManifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			Config:        registry.addBlob([]byte(`{"architecture":"`+arch+`"}`), "application/vnd.oci.image.config.v1+json"),
+			Layers:        []OCIDescriptor{registry.addBlob(layers[arch], "application/vnd.oci.image.layer.v1.tar+gzip")},
+		}, "")
+		desc.Platform = &OCIPlatform{OS: "linux", Architecture: arch}
+		manifests = append(manifests, desc)
+	}
+	registry.addManifest(t, &OCIManifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: manifests}, "latest")
+
+	dl, err := NewHttpDownloader(&HttpDownloaderOpts{})
+	assert.Equal(t, nil, err)
+	client := NewRegistryClient(dl, &RegistryClientOpts{Username: "user", Password: "pass", Platform: "linux/arm64"})
+	image := util.ParseDockerImageNameInfo(registry.URL + "/library/app:latest")
+
+	dir := t.TempDir()
+	manifest, err := client.Pull(context.Background(), image, dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, registry.tokens)
+	buf, err := os.ReadFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(manifest.Layers[0].Digest, "sha256:")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, layers["arm64"], buf)
+	assert.Equal(t, true, fileExists(filepath.Join(dir, "index.json")))
+	assert.Equal(t, true, fileExists(filepath.Join(dir, "oci-layout")))
+
+	// 按照摘要引用时 ParseDockerImageNameInfo 返回的 Path 和 Digest 也能使用
+	image = util.ParseDockerImageNameInfo(registry.URL + "/library/app@" + manifests[0].Digest)
+	manifest, _, digest, err := client.ResolveManifest(context.Background(), image)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, manifests[0].Digest, digest)
+
+	// 仓库返回的 blob 与摘要不一致
+	registry.blobs[manifest.Layers[0].Digest] = bytes.Repeat([]byte("x"), 5*1024)
+	err = client.DownloadBlob(context.Background(), image, manifest.Layers[0], filepath.Join(dir, "corrupt"))
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+
+	// 清单中 urls 指向的外部主机不会收到镜像仓库的认证信息
+	foreign := bytes.Repeat([]byte("foreign"), 1024)
+	var authorization []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = append(authorization, req.Header.Get("Authorization"))
+		http.ServeContent(w, req, "layer", time.Time{}, bytes.NewReader(foreign))
+	}))
+	defer mirror.Close()
+	desc := OCIDescriptor{MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip", Digest: digestOf(foreign), Size: int64(len(foreign)), URLs: []string{mirror.URL + "/layer"}}
+	err = client.DownloadBlob(context.Background(), image, desc, filepath.Join(dir, "foreign"))
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, 0, len(authorization))
+	for _, header := range authorization {
+		assert.Equal(t, "", header)
+	}
+
+	client.Opts.Platform = "windows/amd64"
+	_, err = client.Pull(context.Background(), util.ParseDockerImageNameInfo(registry.URL+"/library/app:latest"), dir)
+	assert.Equal(t, true, errors.Is(err, ErrPlatformNotFound))
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:a/b:pull,push"`)
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:a/b:pull,push"}, params)
+
+	scheme, params = parseAuthChallenge(`Basic realm=registry`)
+	assert.Equal(t, "basic", scheme)
+	assert.Equal(t, "registry", params["realm"])
+}
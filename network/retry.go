@@ -0,0 +1,187 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// defaultRetryableStatusCodes 默认可重试的 HTTP 状态码
+var defaultRetryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy retry policy with exponential backoff for transient download errors, zero value fields use default values
+// RetryPolicy 下载临时错误的指数退避重试策略，零值字段使用默认值
+type RetryPolicy struct {
+	// MaxAttempts max attempts including the first one, values <= 1 disable retry
+	// MaxAttempts 包括第一次在内的最大尝试次数，小于等于1时不重试
+	MaxAttempts int
+	// InitialBackoff wait time before the first retry, default is 1s
+	// InitialBackoff 第一次重试前的等待时间，默认 1s
+	InitialBackoff time.Duration
+	// MaxBackoff max wait time between attempts, default is 30s
+	// MaxBackoff 两次尝试之间的最长等待时间，默认 30s
+	MaxBackoff time.Duration
+	// Multiplier backoff growth factor, default is 2
+	// Multiplier 等待时间的增长倍数，默认 2
+	Multiplier float64
+	// Jitter random fraction 0~1 of backoff to subtract, avoid all segments retrying at the same time
+	// Jitter 从等待时间中随机减去的比例 0~1，避免所有分段同时重试
+	Jitter float64
+	// RetryableStatusCodes status codes worth retrying, default is 408 429 500 502 503 504
+	// RetryableStatusCodes 可重试的 HTTP 状态码，默认 408 429 500 502 503 504
+	RetryableStatusCodes []int
+	// OnRetry called before waiting for each retry, may be called from multiple segment goroutines at the same time
+	// OnRetry 每次重试等待前调用，分段下载时可能被多个协程同时调用
+	OnRetry func(attempt RetryAttempt)
+}
+
+// RetryAttempt information of a retry
+// RetryAttempt 一次重试的信息
+type RetryAttempt struct {
+	// URL 下载地址
+	URL string
+	// Attempt 即将进行的是第几次尝试，从 2 开始
+	Attempt int
+	// Err 上一次尝试的错误
+	Err error
+	// Backoff 重试前等待的时间
+	Backoff time.Duration
+	// Offset 重试时从该字节偏移继续下载
+	Offset int64
+}
+
+func (t *RetryPolicy) maxAttempts() int {
+	if t == nil || t.MaxAttempts < 1 {
+		return 1
+	}
+	return t.MaxAttempts
+}
+
+// backoff 计算第 attempt 次失败后的等待时间，服务器返回 Retry-After 时以其为准
+func (t *RetryPolicy) backoff(attempt int, err error) (d time.Duration) {
+	initial, maxBackoff, multiplier := t.InitialBackoff, t.MaxBackoff, t.Multiplier
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d = time.Duration(float64(initial) * math.Pow(multiplier, float64(attempt-1)))
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	if t.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * math.Min(t.Jitter, 1) * float64(d))
+	}
+
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		if retryAfter, ok := parseRetryAfter(statusErr.Header.Get("Retry-After")); ok && retryAfter > d {
+			d = retryAfter
+		}
+	}
+	return d
+}
+
+// retryable 判断错误是否为值得重试的临时错误，只重试网络错误、超时、响应体提前结束以及可重试的状态码，
+// 本地文件读写、校验和设置、地址解析等其他错误都不会重试
+func (t *RetryPolicy) retryable(err error) bool {
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		codes := t.RetryableStatusCodes
+		if len(codes) < 1 {
+			codes = defaultRetryableStatusCodes
+		}
+		return slices.Contains(codes, statusErr.StatusCode)
+	}
+
+	var (
+		certErr *tls.CertificateVerificationError
+		dnsErr  *net.DNSError
+		opErr   *net.OpError
+		netErr  net.Error
+		urlErr  *url.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, ErrCertificatePinMismatch),
+		errors.As(err, &certErr),
+		errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return false
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &opErr),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.As(err, &urlErr):
+		// 服务器在返回响应之前关闭了连接
+		return errors.Is(urlErr.Err, io.EOF)
+	}
+	return false
+}
+
+// withRetry 按照重试策略执行 fn，每次重试都会从 offset 返回的位置继续下载
+func (t *HttpDownloader) withRetry(ctx context.Context, fileURL string, offset func() int64, fn func() error) (err error) {
+	var policy *RetryPolicy
+	if t.Opts != nil {
+		policy = t.Opts.RetryPolicy
+	}
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || ctx.Err() != nil {
+			return
+		}
+		if attempt >= policy.maxAttempts() || !policy.retryable(err) {
+			return
+		}
+
+		backoff := policy.backoff(attempt, err)
+		if policy.OnRetry != nil {
+			var retryOffset int64
+			if offset != nil {
+				retryOffset = offset()
+			}
+			policy.OnRetry(RetryAttempt{URL: fileURL, Attempt: attempt + 1, Err: err, Backoff: backoff, Offset: retryOffset})
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfter 解析 Retry-After 响应头，支持秒数和 HTTP 日期两种格式
+func parseRetryAfter(value string) (d time.Duration, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	_, parseErr := url.Parse("http://[::1")
+	policy := &RetryPolicy{}
+	for name, c := range map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"503":              {&HttpStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		"429":              {&HttpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		"404":              {&HttpStatusError{StatusCode: http.StatusNotFound}, false},
+		"short read":       {&ShortReadError{URL: "http://example.com", Expected: 2, Actual: 1}, true},
+		"unexpected eof":   {fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		"connection close": {&url.Error{Op: "Get", URL: "http://example.com", Err: io.EOF}, true},
+		"connection reset": {&url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}, true},
+		"timeout":          {&url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded}, true},
+		"unknown host":     {&url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "example.com", IsNotFound: true}}}, false},
+		"canceled":         {&url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}, false},
+		"disk full":        {&os.PathError{Op: "write", Path: "/tmp/file", Err: syscall.ENOSPC}, false},
+		"permission":       {&os.PathError{Op: "open", Path: "/tmp/file", Err: os.ErrPermission}, false},
+		"checksum":         {ErrUnknownChecksumType, false},
+		"url parse":        {parseErr, false},
+		"checksum failed":  {&ChecksumMismatchError{}, false},
+	} {
+		assert.Equal(t, c.retryable, policy.retryable(c.err), name)
+	}
+}
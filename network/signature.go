@@ -0,0 +1,69 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrSignatureMismatch detached signature does not match content
+// ErrSignatureMismatch 分离签名与内容不匹配
+var ErrSignatureMismatch = errors.New("signature mismatch")
+
+// SignatureVerifier verify detached signature of checksum files or downloaded files, implement it to plug in minisign, signify, sigstore and so on
+// SignatureVerifier 校验校验和文件或下载文件的分离签名，实现该接口即可接入 minisign、signify、sigstore 等签名方案
+type SignatureVerifier interface {
+	// Verify return nil if signature is valid for content, ErrSignatureMismatch should be wrapped when signature is wrong
+	// Verify 签名有效时返回 nil，签名错误时返回的错误应当包装 ErrSignatureMismatch
+	Verify(content io.Reader, signature []byte) error
+}
+
+// SignatureVerifierFunc adapter to use ordinary function as SignatureVerifier
+// SignatureVerifierFunc 将普通函数适配为 SignatureVerifier
+type SignatureVerifierFunc func(content io.Reader, signature []byte) error
+
+func (t SignatureVerifierFunc) Verify(content io.Reader, signature []byte) error {
+	return t(content, signature)
+}
+
+// Ed25519Verifier verify raw, base64 or hex encoded ed25519 signature, content is read into memory so it suits checksum files better than large downloads
+// Ed25519Verifier 校验原始、base64 或 hex 编码的 ed25519 签名，内容会被完整读入内存，更适合校验校验和文件而不是大文件
+type Ed25519Verifier struct {
+	PublicKeys []ed25519.PublicKey
+}
+
+func (t *Ed25519Verifier) Verify(content io.Reader, signature []byte) error {
+	sig, err := decodeEd25519Signature(signature)
+	if err != nil {
+		return err
+	}
+	message, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	for _, key := range t.PublicKeys {
+		if ed25519.Verify(key, message, sig) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// decodeEd25519Signature 依次尝试原始字节、base64 和 hex 编码
+func decodeEd25519Signature(signature []byte) (sig []byte, err error) {
+	if len(signature) == ed25519.SignatureSize {
+		return signature, nil
+	}
+	text := string(bytes.TrimSpace(signature))
+	if sig, err = base64.StdEncoding.DecodeString(text); err == nil && len(sig) == ed25519.SignatureSize {
+		return sig, nil
+	}
+	if sig, err = hex.DecodeString(text); err == nil && len(sig) == ed25519.SignatureSize {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%w: invalid ed25519 signature", ErrSignatureMismatch)
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrCertificatePinMismatch none of the server certificates matches the pinned SPKI hashes
+// ErrCertificatePinMismatch 服务器证书的公钥与固定的 SPKI 哈希都不匹配
+var ErrCertificatePinMismatch = errors.New("certificate pin mismatch")
+
+// TLSOpts TLS options of HttpDownloader, certificates are verified against system roots unless InsecureSkipVerify is set
+// TLSOpts HttpDownloader 的 TLS 选项，除非设置 InsecureSkipVerify，否则都会使用系统根证书校验服务器证书
+type TLSOpts struct {
+	// RootCAs root certificate pool, nil means system roots
+	// RootCAs 根证书池，为空时使用系统根证书
+	RootCAs *x509.CertPool
+	// Certificates client certificates for mTLS
+	// Certificates mTLS 使用的客户端证书
+	Certificates []tls.Certificate
+	// MinVersion minimum TLS version, default is tls.VersionTLS12
+	// MinVersion 最低 TLS 版本，默认 tls.VersionTLS12
+	MinVersion uint16
+	// InsecureSkipVerify explicit opt-in to skip server certificate verification
+	// InsecureSkipVerify 显式开启后不再校验服务器证书
+	InsecureSkipVerify bool
+	// PinnedSPKISHA256 sha256 of server certificate SubjectPublicKeyInfo in base64 or hex, connection is refused if none of the chain matches
+	// PinnedSPKISHA256 服务器证书 SubjectPublicKeyInfo 的 sha256，base64 或 hex 编码，证书链中没有任何一个匹配时拒绝连接
+	PinnedSPKISHA256 []string
+}
+
+// LoadCertPool load PEM encoded certificates into a pool based on system roots
+// LoadCertPool 在系统根证书的基础上加载 PEM 格式的证书文件
+func LoadCertPool(pemFiles ...string) (pool *x509.CertPool, err error) {
+	if pool, err = x509.SystemCertPool(); err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, pemFile := range pemFiles {
+		buf, err := os.ReadFile(pemFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificate found in %s", pemFile)
+		}
+	}
+	return pool, nil
+}
+
+// SPKISHA256 return base64 encoded sha256 of certificate SubjectPublicKeyInfo, the value used by PinnedSPKISHA256
+// SPKISHA256 返回证书 SubjectPublicKeyInfo 的 sha256 base64 编码，即 PinnedSPKISHA256 使用的值
+func SPKISHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// newTLSConfig 根据 TLSOpts 生成 tls.Config
+func newTLSConfig(opts *TLSOpts) (config *tls.Config, err error) {
+	config = &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts == nil {
+		return config, nil
+	}
+
+	config.RootCAs = opts.RootCAs
+	config.Certificates = opts.Certificates
+	config.InsecureSkipVerify = opts.InsecureSkipVerify
+	if opts.MinVersion != 0 {
+		config.MinVersion = opts.MinVersion
+	}
+
+	if len(opts.PinnedSPKISHA256) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range opts.PinnedSPKISHA256 {
+			sum, err := decodeSPKIPin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[string(sum)] = true
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifySPKIPins(state, pins)
+		}
+	}
+	return config, nil
+}
+
+// decodeSPKIPin 解析 base64 或 hex 编码的 sha256，可以带有 sha256/ 前缀
+func decodeSPKIPin(pin string) (sum []byte, err error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	if sum, err = base64.StdEncoding.DecodeString(pin); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	if sum, err = hex.DecodeString(pin); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("invalid SPKI sha256 pin %q", pin)
+}
+
+// verifySPKIPins 证书已经校验过时检查整条证书链，跳过校验时只信任服务器证书本身
+func verifySPKIPins(state tls.ConnectionState, pins map[string]bool) error {
+	var certs []*x509.Certificate
+	for _, chain := range state.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(certs) < 1 && len(state.PeerCertificates) > 0 {
+		certs = state.PeerCertificates[:1]
+	}
+
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if pins[string(sum[:])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w for %s", ErrCertificatePinMismatch, state.ServerName)
+}
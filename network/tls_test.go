@@ -0,0 +1,56 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpDownloadTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) < 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	clientCerts := server.TLS.Certificates
+	output := filepath.Join(t.TempDir(), "file")
+	download := func(opts *TLSOpts) error {
+		dl, err := NewHttpDownloader(&HttpDownloaderOpts{TLS: opts})
+		if err != nil {
+			return err
+		}
+		return dl.Download(context.Background(), &DownloadOpts{FileURL: server.URL, OutputFilename: output})
+	}
+
+	// 默认校验服务器证书
+	var certErr *tls.CertificateVerificationError
+	assert.Equal(t, true, errors.As(download(nil), &certErr))
+
+	assert.Equal(t, nil, download(&TLSOpts{RootCAs: pool, Certificates: clientCerts}))
+	assert.Equal(t, nil, download(&TLSOpts{InsecureSkipVerify: true, Certificates: clientCerts}))
+
+	pin := SPKISHA256(server.Certificate())
+	assert.Equal(t, nil, download(&TLSOpts{RootCAs: pool, Certificates: clientCerts, PinnedSPKISHA256: []string{pin}}))
+
+	wrongPin := "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	err := download(&TLSOpts{RootCAs: pool, Certificates: clientCerts, PinnedSPKISHA256: []string{wrongPin}})
+	assert.Equal(t, true, errors.Is(err, ErrCertificatePinMismatch))
+
+	_, err = NewHttpDownloader(&HttpDownloaderOpts{TLS: &TLSOpts{PinnedSPKISHA256: []string{"invalid"}}})
+	assert.NotEqual(t, nil, err)
+}